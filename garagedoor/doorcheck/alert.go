@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+type alertConfig struct {
+    // how long the door may be open at night before the first alert
+    GracePeriod time.Duration `yaml:"grace_period"`
+    // wait before the first reminder, multiplied by ReminderBackoff after
+    // every reminder up to MaxReminderInterval
+    ReminderInterval time.Duration `yaml:"reminder_interval"`
+    ReminderBackoff float64 `yaml:"reminder_backoff"`
+    MaxReminderInterval time.Duration `yaml:"max_reminder_interval"`
+    // alert when the door is open longer than this during the day, 0
+    // disables daytime alerts
+    DayOpenLimit time.Duration `yaml:"day_open_limit"`
+}
+
+func (ac *alertConfig) setDefaults() {
+    if ac.GracePeriod == 0 {
+        ac.GracePeriod = 5 * time.Minute
+    }
+    if ac.ReminderInterval == 0 {
+        ac.ReminderInterval = 15 * time.Minute
+    }
+    if ac.ReminderBackoff < 1 {
+        ac.ReminderBackoff = 2
+    }
+    if ac.MaxReminderInterval == 0 {
+        ac.MaxReminderInterval = 2 * time.Hour
+    }
+}
+
+// alerter tracks a single opening of the door and decides when to alert.
+// Once an alert went out, reminders keep coming at growing intervals until
+// the door closes, whether or not it is still night, and closing it sends a
+// final resolution message.
+type alerter struct {
+    door string
+    cfg alertConfig
+    notifiers []Notifier
+    // alerts are also streamed on /events when set
+    events *eventHub
+    // alerts and reminders are held back while snoozed
+    snoozes *snoozes
+
+    openedAt time.Time
+    alerted bool
+    reminder time.Duration
+    nextAlert time.Time
+}
+
+func newAlerter(door string, cfg alertConfig, notifiers []Notifier) *alerter {
+    return &alerter{
+        door: door,
+        cfg: cfg,
+        notifiers: notifiers,
+    }
+}
+
+// update feeds a sensor reading taken at now into the state machine.
+func (a *alerter) update(s sensor.State, night bool, now time.Time) {
+    if s != sensor.Open {
+        if !a.openedAt.IsZero() && a.alerted {
+            a.send(fmt.Sprintf("Door closed after %s: %s", formatMinutes(now.Sub(a.openedAt)), now.Format(time.RFC1123)))
+        }
+        a.reset()
+        return
+    }
+
+    if a.openedAt.IsZero() {
+        a.openedAt = now
+    }
+    openFor := now.Sub(a.openedAt)
+    if a.snoozes.snoozed(a.door, now) {
+        log.Printf("Door %s open for %s, alerts snoozed", a.door, formatMinutes(openFor))
+        return
+    }
+
+    switch {
+    case a.alerted:
+        if now.Before(a.nextAlert) {
+            return
+        }
+        a.send(fmt.Sprintf("Reminder: door still open, open for %s since %s", formatMinutes(openFor), a.openedAt.Format(time.Kitchen)))
+        a.reminder = min(time.Duration(float64(a.reminder)*a.cfg.ReminderBackoff), a.cfg.MaxReminderInterval)
+        a.nextAlert = now.Add(a.reminder)
+    case night && openFor >= a.cfg.GracePeriod:
+        a.firstAlert(fmt.Sprintf("Door open at night, open for %s since %s", formatMinutes(openFor), a.openedAt.Format(time.Kitchen)), now)
+    case !night && a.cfg.DayOpenLimit > 0 && openFor >= a.cfg.DayOpenLimit:
+        a.firstAlert(fmt.Sprintf("Door left open for %s since %s", formatMinutes(openFor), a.openedAt.Format(time.Kitchen)), now)
+    default:
+        log.Printf("Door %s open for %s, no alert yet", a.door, formatMinutes(openFor))
+    }
+}
+
+func (a *alerter) firstAlert(message string, now time.Time) {
+    a.send(message)
+    a.alerted = true
+    a.reminder = a.cfg.ReminderInterval
+    a.nextAlert = now.Add(a.reminder)
+}
+
+func (a *alerter) send(message string) {
+    if a.events != nil {
+        a.events.publish(eventAlert, alertEvent{Door: a.door, Time: now(), Message: message})
+    }
+    message = fmt.Sprintf("[%s] %s", a.door, message)
+    log.Println(message)
+    sendNotification(a.notifiers, message)
+}
+
+func (a *alerter) reset() {
+    a.openedAt = time.Time{}
+    a.alerted = false
+    a.reminder = 0
+    a.nextAlert = time.Time{}
+}
+
+// formatMinutes formats d rounded to the minute, as 1h5m, 2h or 45m.
+func formatMinutes(d time.Duration) string {
+    d = d.Round(time.Minute)
+    h, m := int(d.Hours()), int(d.Minutes())%60
+    switch {
+    case h == 0:
+        return fmt.Sprintf("%dm", m)
+    case m == 0:
+        return fmt.Sprintf("%dh", h)
+    }
+    return fmt.Sprintf("%dh%dm", h, m)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+type chanNotifier chan string
+
+func (n chanNotifier) Name() string { return "test" }
+
+func (n chanNotifier) Notify(_ context.Context, message string) error {
+	n <- message
+	return nil
+}
+
+func (n chanNotifier) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case m := <-n:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("want notification, got none")
+		return ""
+	}
+}
+
+func (n chanNotifier) none(t *testing.T) {
+	t.Helper()
+	select {
+	case m := <-n:
+		t.Fatalf("want no notification, got %q", m)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestAlerter__EscalatesAndResolves(t *testing.T) {
+	t.Parallel()
+	n := make(chanNotifier, 10)
+	cfg := alertConfig{}
+	cfg.setDefaults()
+	a := newAlerter("garage", cfg, []Notifier{n})
+
+	open, closed := sensor.Open, sensor.Closed
+	start := time.Date(2024, 6, 4, 23, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	a.update(open, true, at(0))
+	a.update(open, true, at(4*time.Minute))
+	n.none(t)
+
+	a.update(open, true, at(5*time.Minute))
+	if m := n.next(t); !strings.HasPrefix(m, "[garage] Door open at night") {
+		t.Errorf("want night alert, got %q", m)
+	}
+
+	// reminders after 15m, then 30m more, even once night is over
+	a.update(open, true, at(19*time.Minute))
+	n.none(t)
+	a.update(open, false, at(20*time.Minute))
+	if m := n.next(t); !strings.HasPrefix(m, "[garage] Reminder") {
+		t.Errorf("want reminder, got %q", m)
+	}
+	a.update(open, false, at(49*time.Minute))
+	n.none(t)
+	a.update(open, false, at(50*time.Minute))
+	n.next(t)
+
+	a.update(closed, false, at(62*time.Minute))
+	if m := n.next(t); !strings.HasPrefix(m, "[garage] Door closed after 1h2m") {
+		t.Errorf("want resolution message, got %q", m)
+	}
+}
+
+func TestAlerter__DaytimeLimit(t *testing.T) {
+	t.Parallel()
+	n := make(chanNotifier, 10)
+	cfg := alertConfig{DayOpenLimit: 30 * time.Minute}
+	cfg.setDefaults()
+	a := newAlerter("garage", cfg, []Notifier{n})
+
+	start := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	a.update(sensor.Open, false, start)
+	a.update(sensor.Open, false, start.Add(29*time.Minute))
+	n.none(t)
+	a.update(sensor.Open, false, start.Add(30*time.Minute))
+	if m := n.next(t); !strings.HasPrefix(m, "[garage] Door left open for 30m") {
+		t.Errorf("want daytime alert, got %q", m)
+	}
+}
+
+func TestAlerter__NoResolutionWithoutAlert(t *testing.T) {
+	t.Parallel()
+	n := make(chanNotifier, 10)
+	cfg := alertConfig{}
+	cfg.setDefaults()
+	a := newAlerter("garage", cfg, []Notifier{n})
+
+	start := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	a.update(sensor.Open, false, start)
+	a.update(sensor.Closed, false, start.Add(time.Hour))
+	n.none(t)
+}
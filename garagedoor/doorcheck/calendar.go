@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+    // an override treating its whole span as night, alerting as soon as
+    // the grace period is over, e.g. while on vacation
+    overrideNight = "night"
+    // an override with no night at all
+    overrideDay = "day"
+
+    // how often the calendar file is checked for changes
+    calendarRecheck = time.Minute
+)
+
+// override is a calendar event replacing the night window while it lasts.
+type override struct {
+    Summary string `json:"summary"`
+    Mode string `json:"mode"`
+    Start time.Time `json:"start"`
+    End time.Time `json:"end"`
+}
+
+func (o override) covers(t time.Time) bool {
+    return !t.Before(o.Start) && t.Before(o.End)
+}
+
+// parseICS reads the VEVENTs of an iCalendar file as overrides. An event
+// is a night override unless its CATEGORIES include "day". All-day events
+// cover whole days in loc, recurring events are not supported.
+func parseICS(r io.Reader, loc *time.Location) ([]override, error) {
+    // Long lines are folded, continuation lines start with a space or tab
+    var lines []string
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := strings.TrimRight(scanner.Text(), "\r")
+        if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+            lines[len(lines)-1] += line[1:]
+            continue
+        }
+        lines = append(lines, line)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    var overrides []override
+    var o *override
+    for n, line := range lines {
+        name, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        name, params, _ := strings.Cut(name, ";")
+        switch {
+        case name == "BEGIN" && value == "VEVENT":
+            o = &override{Mode: overrideNight}
+        case o == nil:
+        case name == "END" && value == "VEVENT":
+            if o.Start.IsZero() {
+                return nil, fmt.Errorf("line %d: event %q has no DTSTART", n+1, o.Summary)
+            }
+            if o.End.IsZero() {
+                // an event without DTEND lasts a day
+                o.End = o.Start.AddDate(0, 0, 1)
+            }
+            overrides = append(overrides, *o)
+            o = nil
+        case name == "SUMMARY":
+            o.Summary = unescapeICS(value)
+        case name == "CATEGORIES":
+            for _, c := range strings.Split(value, ",") {
+                if strings.EqualFold(strings.TrimSpace(c), overrideDay) {
+                    o.Mode = overrideDay
+                }
+            }
+        case name == "DTSTART", name == "DTEND":
+            t, err := parseICSTime(value, params, loc)
+            if err != nil {
+                return nil, fmt.Errorf("line %d: %w", n+1, err)
+            }
+            if name == "DTSTART" {
+                o.Start = t
+            } else {
+                o.End = t
+            }
+        case name == "RRULE":
+            log.Printf("Calendar event %q repeats, only its first occurrence is used", o.Summary)
+        }
+    }
+    return overrides, nil
+}
+
+func parseICSTime(value, params string, loc *time.Location) (time.Time, error) {
+    for _, p := range strings.Split(params, ";") {
+        if tzid, ok := strings.CutPrefix(p, "TZID="); ok {
+            l, err := time.LoadLocation(strings.Trim(tzid, `"`))
+            if err != nil {
+                return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+            }
+            loc = l
+        }
+    }
+    switch {
+    case len(value) == len("20060102"):
+        return time.ParseInLocation("20060102", value, loc)
+    case strings.HasSuffix(value, "Z"):
+        return time.Parse("20060102T150405Z", value)
+    default:
+        return time.ParseInLocation("20060102T150405", value, loc)
+    }
+}
+
+func unescapeICS(s string) string {
+    return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// calendar holds the overrides of an .ics file, reloaded when the file
+// changes so overrides can be added without a restart.
+type calendar struct {
+    path string
+
+    mu sync.Mutex
+    checked time.Time
+    modTime time.Time
+    overrides []override
+}
+
+func openCalendar(path string) (*calendar, error) {
+    c := &calendar{path: path}
+    if err := c.load(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+func (c *calendar) load() error {
+    f, err := os.Open(c.path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    fi, err := f.Stat()
+    if err != nil {
+        return err
+    }
+    overrides, err := parseICS(f, time.Local)
+    if err != nil {
+        return fmt.Errorf("parsing calendar %s: %w", c.path, err)
+    }
+    c.modTime = fi.ModTime()
+    c.overrides = overrides
+    log.Printf("Loaded %d night overrides from %s", len(overrides), c.path)
+    return nil
+}
+
+// at returns the override covering t, if any. When overrides overlap the
+// one that started last wins.
+func (c *calendar) at(t time.Time) (override, bool) {
+    if c == nil {
+        return override{}, false
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if time.Since(c.checked) >= calendarRecheck {
+        c.checked = time.Now()
+        if fi, err := os.Stat(c.path); err != nil {
+            log.Println("Error checking calendar:", err)
+        } else if !fi.ModTime().Equal(c.modTime) {
+            if err := c.load(); err != nil {
+                // keep the overrides loaded last
+                log.Println("Error reloading calendar:", err)
+            }
+        }
+    }
+
+    var found []override
+    for _, o := range c.overrides {
+        if o.covers(t) {
+            found = append(found, o)
+        }
+    }
+    if len(found) == 0 {
+        return override{}, false
+    }
+    return slices.MaxFunc(found, func(a, b override) int { return a.Start.Compare(b.Start) }), true
+}
@@ -2,30 +2,127 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/ezebunandu/gohome/httpauth"
 	"gopkg.in/yaml.v3"
 )
 
+// yamlHour is a time of day, either fixed ("9:00pm") or relative to the
+// sun ("sunset+30m", "sunrise-15m").
 type yamlHour struct {
     t time.Time
+    // sunrise or sunset, empty for fixed times
+    event string
+    offset time.Duration
 }
 
 func (yh *yamlHour) UnmarshalYAML(v *yaml.Node) error {
     if v.Kind != yaml.ScalarNode {
         return errors.New("value is not scaler")
     }
+    value := strings.ReplaceAll(v.Value, " ", "")
+    for _, event := range []string{sunrise, sunset} {
+        rest, ok := strings.CutPrefix(value, event)
+        if !ok {
+            continue
+        }
+        yh.event = event
+        if rest == "" {
+            return nil
+        }
+        if rest[0] != '+' && rest[0] != '-' {
+            return fmt.Errorf("invalid time %q, want an offset like %s+30m", v.Value, event)
+        }
+        var err error
+        yh.offset, err = time.ParseDuration(rest)
+        return err
+    }
     var err error
     yh.t, err = time.Parse("3:04pm", v.Value)
     return err
 }
 
+func (yh yamlHour) isSet() bool {
+    return yh.event != "" || !yh.t.IsZero()
+}
+
+// on returns the time yh stands for on the day of t, in t's location. sun
+// is only used for sunrise and sunset relative times.
+func (yh yamlHour) on(t time.Time, sun *sunClock) time.Time {
+    y, m, d := t.Date()
+    if yh.event == "" {
+        return time.Date(y, m, d, yh.t.Hour(), yh.t.Minute(), 0, 0, t.Location())
+    }
+    rise, set := sun.times(t)
+    at := rise
+    if yh.event == sunset {
+        at = set
+    }
+    return at.Add(yh.offset).Truncate(time.Minute)
+}
+
+func (yh yamlHour) String() string {
+    switch {
+    case yh.event == "":
+        return yh.t.Format("3:04pm")
+    case yh.offset == 0:
+        return yh.event
+    case yh.offset > 0:
+        return yh.event + "+" + yh.offset.String()
+    default:
+        return yh.event + yh.offset.String()
+    }
+}
+
+const (
+    gpioRPIO = "rpio"
+    gpioSim = "sim"
+)
+
+type gpioConfig struct {
+    // rpio (default) reads the Raspberry Pi pins, sim simulates them
+    Backend string `yaml:"backend"`
+    // simulated pin levels are read from this file whenever it changes
+    SimFile string `yaml:"sim_file"`
+    // scripted sequence of simulated level changes played at startup
+    SimScript string `yaml:"sim_script"`
+}
+
 type config struct {
+    GPIO gpioConfig `yaml:"gpio"`
+    // SwitchPinNumber and Relay describe a single door, Doors replaces them
+    // for setups with more than one
     SwitchPinNumber int `yaml:"switch_pin_number"`
+    Doors []doorConfig `yaml:"doors"`
+    // default night window for doors that don't set their own
     NightStart yamlHour `yaml:"night_start"`
     NightEnd yamlHour `yaml:"night_end"`
+    // weekday (monday or mon...) windows replacing the default one, start
+    // or end left out are taken from night_start and night_end
+    NightWeekdays map[string]nightWindow `yaml:"night_weekdays"`
+    // iCalendar file of dates overriding the night windows
+    CalendarFile string `yaml:"calendar_file"`
+    // location used to work out sunrise and sunset, in degrees with north
+    // and east positive
+    Latitude float64 `yaml:"latitude"`
+    Longitude float64 `yaml:"longitude"`
+    HistoryFile string `yaml:"history_file"`
+    // active alert snoozes, kept across restarts
+    SnoozeFile string `yaml:"snooze_file"`
+    MonitorMode string `yaml:"monitor_mode"`
+    PollInterval time.Duration `yaml:"poll_interval"`
+    Debounce time.Duration `yaml:"debounce"`
+    Notifiers []notifierConfig `yaml:"notifiers"`
+    Alerts alertConfig `yaml:"alerts"`
+    Relay relayConfig `yaml:"relay"`
+    MQTT mqttConfig `yaml:"mqtt"`
+    // API keys, the relay routes are only served with a secrets file
+    Auth httpauth.Config `yaml:"auth"`
 }
 
 func newConfig(configFile string) (*config, error) {
@@ -39,23 +136,103 @@ func newConfig(configFile string) (*config, error) {
     if err := yaml.NewDecoder(cf).Decode(&cfg); err != nil {
         return nil, err
     }
-    if cfg.SwitchPinNumber == 0 {
+    switch {
+    case cfg.SwitchPinNumber != 0 && len(cfg.Doors) > 0:
+        return nil, errors.New("use either switch_pin_number or doors, not both")
+    case cfg.SwitchPinNumber != 0:
+        cfg.Doors = []doorConfig{{
+            Name: defaultDoorName,
+            PinNumber: cfg.SwitchPinNumber,
+            Relay: cfg.Relay,
+        }}
+    case len(cfg.Doors) == 0:
         log.Println(cfg)
-        return nil, errors.New("switch pin needs to be defined")
+        return nil, errors.New("switch pin or doors need to be defined")
     }
-    if cfg.NightStart.t.IsZero() {
+    if !cfg.NightStart.isSet() {
         var err error
         cfg.NightStart.t, err = time.Parse("3:04pm", "9:00pm")
         if err != nil {
             return nil, err
         }
     }
-    if cfg.NightEnd.t.IsZero() {
+    if !cfg.NightEnd.isSet() {
         var err error
         cfg.NightEnd.t, err = time.Parse("3:04pm", "7:00am")
         if err != nil {
             return nil, err
         }
     }
+    switch cfg.MonitorMode {
+    case "":
+        cfg.MonitorMode = monitorPoll
+    case monitorPoll, monitorEdge:
+    default:
+        return nil, fmt.Errorf("invalid monitor mode %q, must be %q or %q", cfg.MonitorMode, monitorPoll, monitorEdge)
+    }
+    if cfg.PollInterval == 0 {
+        cfg.PollInterval = 1 * time.Minute
+    }
+    switch cfg.GPIO.Backend {
+    case "":
+        cfg.GPIO.Backend = gpioRPIO
+    case gpioRPIO, gpioSim:
+    default:
+        return nil, fmt.Errorf("invalid gpio backend %q, must be %q or %q", cfg.GPIO.Backend, gpioRPIO, gpioSim)
+    }
+    if cfg.Debounce == 0 {
+        cfg.Debounce = 100 * time.Millisecond
+    }
+    //Keep the Discord only setup working: the webhook env var alone is
+    //enough when no notifiers are listed
+    if discordWebhookURL, ok := os.LookupEnv("DISCORD_WEBHOOK_URL"); ok && len(cfg.Notifiers) == 0 {
+        cfg.Notifiers = append(cfg.Notifiers, notifierConfig{
+            Type: notifierDiscord,
+            URL: discordWebhookURL,
+        })
+    }
+    if len(cfg.Notifiers) == 0 {
+        return nil, errors.New("at least one notifier, or the 'DISCORD_WEBHOOK_URL' env, is required")
+    }
+    cfg.Alerts.setDefaults()
+    cfg.MQTT.setDefaults()
+    names := make(map[string]bool)
+    for i := range cfg.Doors {
+        dc := &cfg.Doors[i]
+        if err := dc.validate(); err != nil {
+            return nil, err
+        }
+        if names[dc.Name] {
+            return nil, fmt.Errorf("duplicate door name %q", dc.Name)
+        }
+        names[dc.Name] = true
+        if !dc.NightStart.isSet() {
+            dc.NightStart = cfg.NightStart
+        }
+        if !dc.NightEnd.isSet() {
+            dc.NightEnd = cfg.NightEnd
+        }
+        if dc.NightWeekdays == nil {
+            dc.NightWeekdays = cfg.NightWeekdays
+        }
+        sched, err := newSchedule(nightWindow{dc.NightStart, dc.NightEnd}, dc.NightWeekdays, nil, nil)
+        if err != nil {
+            return nil, fmt.Errorf("door %s: %w", dc.Name, err)
+        }
+        for _, yh := range sched.hours() {
+            if yh.event != "" && cfg.Latitude == 0 && cfg.Longitude == 0 {
+                return nil, fmt.Errorf("door %s: night window %q needs latitude and longitude", dc.Name, yh)
+            }
+        }
+    }
+    if cfg.Latitude < -90 || cfg.Latitude > 90 || cfg.Longitude < -180 || cfg.Longitude > 180 {
+        return nil, fmt.Errorf("invalid latitude/longitude %v/%v", cfg.Latitude, cfg.Longitude)
+    }
+    if cfg.HistoryFile == "" {
+        cfg.HistoryFile = "history.jsonl"
+    }
+    if cfg.SnoozeFile == "" {
+        cfg.SnoozeFile = "snoozes.json"
+    }
     return cfg, nil
 }
\ No newline at end of file
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"doorcheck/gpio"
+	"doorcheck/sensor"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// name of the door built from the single door switch_pin_number config
+const defaultDoorName = "garage"
+
+type doorConfig struct {
+    Name string `yaml:"name"`
+    PinNumber int `yaml:"pin_number"`
+    // up (default), down or off
+    Pull string `yaml:"pull"`
+    // pin level read while the door is open: low (default) or high
+    OpenWhen string `yaml:"open_when"`
+    NightStart yamlHour `yaml:"night_start"`
+    NightEnd yamlHour `yaml:"night_end"`
+    NightWeekdays map[string]nightWindow `yaml:"night_weekdays"`
+    Relay relayConfig `yaml:"relay"`
+}
+
+func (dc *doorConfig) validate() error {
+    if dc.Name == "" || strings.ContainsAny(dc.Name, "/ ") {
+        return fmt.Errorf("invalid door name %q, must be non-empty without spaces or slashes", dc.Name)
+    }
+    if dc.PinNumber == 0 {
+        return fmt.Errorf("door %s: pin_number needs to be defined", dc.Name)
+    }
+    if dc.Pull == "" {
+        dc.Pull = "up"
+    }
+    if _, err := sensor.ParsePull(dc.Pull); err != nil {
+        return fmt.Errorf("door %s: %w", dc.Name, err)
+    }
+    switch dc.OpenWhen {
+    case "":
+        dc.OpenWhen = "low"
+    case "low", "high":
+    default:
+        return fmt.Errorf("door %s: invalid open_when %q, must be low or high", dc.Name, dc.OpenWhen)
+    }
+    dc.Relay.setDefaults()
+    return nil
+}
+
+// door is a single monitored door and its reed switch.
+type door struct {
+    cfg doorConfig
+    pin gpio.Pin
+    relay *relay
+    schedule *schedule
+    // unix nanoseconds of the last sensor read, for the health checks
+    lastRead atomic.Int64
+}
+
+// setupDoor configures the sensor pin, and the relay pin if any.
+func setupDoor(backend gpio.Backend, dc doorConfig, sched *schedule) *door {
+    d := &door{
+        cfg: dc,
+        pin: backend.Pin(dc.PinNumber),
+        schedule: sched,
+    }
+    d.pin.Input()
+    pull, _ := sensor.ParsePull(dc.Pull)
+    d.pin.Pull(pull)
+
+    if dc.Relay.PinNumber != 0 {
+        d.relay = setupRelay(dc.Relay, d, backend.Pin(dc.Relay.PinNumber))
+    }
+    return d
+}
+
+func (d *door) name() string {
+    return d.cfg.Name
+}
+
+// read returns the door state, whatever the wiring of the sensor.
+func (d *door) read() sensor.State {
+    openWhen := rpio.Low
+    if d.cfg.OpenWhen == "high" {
+        openWhen = rpio.High
+    }
+    s := sensor.Read(d.pin, openWhen)
+    d.lastRead.Store(now().UnixNano())
+    return s
+}
+
+func (d *door) lastReadAt() (time.Time, bool) {
+    n := d.lastRead.Load()
+    if n == 0 {
+        return time.Time{}, false
+    }
+    return time.Unix(0, n), true
+}
+
+func (d *door) isNight() bool {
+    return d.schedule.isNight(now())
+}
+
+type doorStatus struct {
+    Name string `json:"name"`
+    DoorState sensor.State `json:"door_state"`
+    DoorStateText string `json:"door_state_text"`
+}
+
+func (d *door) status() doorStatus {
+    doorState := d.read()
+    return doorStatus{
+        Name: d.name(),
+        DoorState: doorState,
+        DoorStateText: fmt.Sprint(doorState),
+    }
+}
+
+func findDoor(doors []*door, name string) *door {
+    for _, d := range doors {
+        if d.name() == name {
+            return d
+        }
+    }
+    return nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Println("Error replying:", err)
+    }
+}
+
+func doorsHandler(doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, _ *http.Request) {
+        statuses := make([]doorStatus, 0, len(doors))
+        for _, d := range doors {
+            statuses = append(statuses, d.status())
+        }
+        writeJSON(w, statuses)
+    }
+}
+
+func doorHandler(doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        d := findDoor(doors, r.PathValue("name"))
+        if d == nil {
+            http.Error(w, fmt.Sprintf("unknown door %q", r.PathValue("name")), http.StatusNotFound)
+            return
+        }
+        writeJSON(w, d.status())
+    }
+}
+
+func doorToggleHandler(doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        d := findDoor(doors, r.PathValue("name"))
+        if d == nil {
+            http.Error(w, fmt.Sprintf("unknown door %q", r.PathValue("name")), http.StatusNotFound)
+            return
+        }
+        toggleHandler(d.relay)(w, r)
+    }
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+    eventDoor = "door"
+    eventAlert = "alert"
+
+    // events kept for Last-Event-ID replay
+    recentEvents = 100
+    // buffered events per subscriber, a subscriber falling further behind
+    // is disconnected and catches up through replay when it reconnects
+    subscriberBuffer = 16
+    sseHeartbeat = 15 * time.Second
+)
+
+// streamEvent is a single Server-Sent Event.
+type streamEvent struct {
+    ID uint64
+    Type string
+    Data any
+}
+
+// alertEvent is streamed whenever an alert notification goes out.
+type alertEvent struct {
+    Door string `json:"door"`
+    Time time.Time `json:"time"`
+    Message string `json:"message"`
+}
+
+// eventHub fans door transitions and alerts out to the /events
+// subscribers, and keeps the most recent ones for replay.
+type eventHub struct {
+    mu sync.Mutex
+    lastID uint64
+    recent []streamEvent
+    subscribers map[chan streamEvent]struct{}
+    closed bool
+}
+
+func newEventHub() *eventHub {
+    return &eventHub{subscribers: make(map[chan streamEvent]struct{})}
+}
+
+// publish never blocks, slow subscribers are dropped.
+func (h *eventHub) publish(typ string, data any) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    h.lastID++
+    e := streamEvent{ID: h.lastID, Type: typ, Data: data}
+    h.recent = append(h.recent, e)
+    if len(h.recent) > recentEvents {
+        h.recent = h.recent[len(h.recent)-recentEvents:]
+    }
+    for ch := range h.subscribers {
+        select {
+        case ch <- e:
+        default:
+            delete(h.subscribers, ch)
+            close(ch)
+        }
+    }
+}
+
+// subscribe returns the recent events after lastID and a channel of the
+// events published from then on. A lastID the hub does not know, e.g. from
+// before a restart, replays everything kept. The channel is closed when
+// the subscriber falls behind, the hub is closed or unsubscribe is called.
+func (h *eventHub) subscribe(lastID uint64, replay bool) ([]streamEvent, <-chan streamEvent, func()) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    var missed []streamEvent
+    if replay {
+        if lastID > h.lastID {
+            lastID = 0
+        }
+        for _, e := range h.recent {
+            if e.ID > lastID {
+                missed = append(missed, e)
+            }
+        }
+    }
+
+    ch := make(chan streamEvent, subscriberBuffer)
+    if h.closed {
+        close(ch)
+        return missed, ch, func() {}
+    }
+    h.subscribers[ch] = struct{}{}
+    unsubscribe := func() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        if _, ok := h.subscribers[ch]; ok {
+            delete(h.subscribers, ch)
+            close(ch)
+        }
+    }
+    return missed, ch, unsubscribe
+}
+
+// close ends every stream, the server waits for them on shutdown.
+func (h *eventHub) close() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.closed = true
+    for ch := range h.subscribers {
+        delete(h.subscribers, ch)
+        close(ch)
+    }
+}
+
+func writeEvent(w http.ResponseWriter, e streamEvent) error {
+    b, err := json.Marshal(e.Data)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+    return err
+}
+
+// eventsHandler streams door transitions and alerts as Server-Sent Events,
+// with a comment line every heartbeat to keep proxies from timing out.
+func eventsHandler(h *eventHub, heartbeat time.Duration) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        rc := http.NewResponseController(w)
+        // The stream outlives the server's write timeout
+        if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+            log.Println("Error clearing write deadline:", err)
+        }
+
+        var lastID uint64
+        lastEventID := r.Header.Get("Last-Event-ID")
+        if lastEventID != "" {
+            var err error
+            lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+            if err != nil {
+                http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+                return
+            }
+        }
+        missed, events, unsubscribe := h.subscribe(lastID, lastEventID != "")
+        defer unsubscribe()
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.WriteHeader(http.StatusOK)
+        for _, e := range missed {
+            if err := writeEvent(w, e); err != nil {
+                return
+            }
+        }
+        if err := rc.Flush(); err != nil {
+            log.Println("Error flushing event stream:", err)
+            return
+        }
+
+        ticker := time.NewTicker(heartbeat)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-r.Context().Done():
+                return
+            case e, ok := <-events:
+                if !ok {
+                    log.Println("Closing event stream")
+                    return
+                }
+                if err := writeEvent(w, e); err != nil {
+                    return
+                }
+            case <-ticker.C:
+                if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+                    return
+                }
+            }
+            if err := rc.Flush(); err != nil {
+                return
+            }
+        }
+    }
+}
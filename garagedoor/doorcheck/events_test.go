@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents returns the next n events of an SSE stream as their raw
+// "id/event/data" blocks, skipping heartbeats.
+func readEvents(t *testing.T, sc *bufio.Scanner, n int) []string {
+	t.Helper()
+	var events []string
+	var block []string
+	for len(events) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "" && len(block) > 0:
+			events = append(events, strings.Join(block, "\n"))
+			block = nil
+		case line == "", strings.HasPrefix(line, ":"):
+		default:
+			block = append(block, line)
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("stream ended after %d events, want %d: %v", len(events), n, sc.Err())
+	}
+	return events
+}
+
+func openStream(t *testing.T, ctx context.Context, url, lastEventID string) *bufio.Scanner {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("want an event stream, got %s", ct)
+	}
+	return bufio.NewScanner(resp.Body)
+}
+
+func TestEventsHandler__StreamsToEverySubscriber(t *testing.T) {
+	t.Parallel()
+	h := newEventHub()
+	ts := httptest.NewServer(eventsHandler(h, 5*time.Millisecond))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := openStream(t, ctx, ts.URL, "")
+	b := openStream(t, ctx, ts.URL, "")
+	waitFor(t, func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return len(h.subscribers) == 2
+	})
+
+	h.publish(eventAlert, alertEvent{Door: "bay1", Message: "Door open at night"})
+	for _, sc := range []*bufio.Scanner{a, b} {
+		got := readEvents(t, sc, 1)[0]
+		if !strings.HasPrefix(got, "id: 1\nevent: alert\ndata: {\"door\":\"bay1\"") {
+			t.Errorf("unexpected event %q", got)
+		}
+	}
+}
+
+func TestEventsHandler__ReplaysAfterLastEventID(t *testing.T) {
+	t.Parallel()
+	h := newEventHub()
+	for _, door := range []string{"bay1", "bay2", "side"} {
+		h.publish(eventDoor, doorEvent{Door: door})
+	}
+	ts := httptest.NewServer(eventsHandler(h, time.Minute))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := readEvents(t, openStream(t, ctx, ts.URL, "1"), 2)
+	if !strings.HasPrefix(got[0], "id: 2\n") || !strings.HasPrefix(got[1], "id: 3\n") {
+		t.Errorf("want events 2 and 3 replayed, got %q", got)
+	}
+
+	// an ID from before a restart replays everything kept
+	got = readEvents(t, openStream(t, ctx, ts.URL, "42"), 3)
+	if !strings.HasPrefix(got[0], "id: 1\n") {
+		t.Errorf("want every event replayed for an unknown ID, got %q", got)
+	}
+}
+
+func TestEventHub__DropsSlowSubscribers(t *testing.T) {
+	t.Parallel()
+	h := newEventHub()
+	_, events, unsubscribe := h.subscribe(0, false)
+	defer unsubscribe()
+
+	for range subscriberBuffer + 1 {
+		h.publish(eventDoor, doorEvent{})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("want %d buffered events before the channel closes, got %d", subscriberBuffer, n)
+	}
+}
@@ -0,0 +1,64 @@
+// Package gpio abstracts the GPIO pins used by doorcheck so it can run on a
+// Raspberry Pi through go-rpio, or against simulated pins anywhere else.
+package gpio
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Pin is a single GPIO line. rpio.Pin satisfies it as is.
+type Pin interface {
+	Input()
+	Output()
+	Pull(pull rpio.Pull)
+	Read() rpio.State
+	Write(state rpio.State)
+	Detect(edge rpio.Edge)
+	EdgeDetected() bool
+}
+
+// Backend hands out pins and releases the underlying GPIO access on Close.
+type Backend interface {
+	Pin(number int) Pin
+	Close() error
+}
+
+type rpioBackend struct{}
+
+// OpenRPIO maps the Raspberry Pi GPIO memory through go-rpio.
+func OpenRPIO() (Backend, error) {
+	if err := rpio.Open(); err != nil {
+		return nil, err
+	}
+	return rpioBackend{}, nil
+}
+
+func (rpioBackend) Pin(number int) Pin {
+	return rpio.Pin(number)
+}
+
+func (rpioBackend) Close() error {
+	return rpio.Close()
+}
+
+// ParseLevel parses "low", "high", "0" or "1".
+func ParseLevel(s string) (rpio.State, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low", "0":
+		return rpio.Low, nil
+	case "high", "1":
+		return rpio.High, nil
+	}
+	return rpio.Low, fmt.Errorf("invalid level %q, must be low or high", s)
+}
+
+// FormatLevel is the inverse of ParseLevel.
+func FormatLevel(level rpio.State) string {
+	if level == rpio.Low {
+		return "low"
+	}
+	return "high"
+}
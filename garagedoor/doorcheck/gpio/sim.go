@@ -0,0 +1,291 @@
+package gpio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// Sim is a Backend of simulated pins. Input levels are driven with Set,
+// either directly, from a levels file, a scripted sequence or over HTTP.
+type Sim struct {
+	mu   sync.Mutex
+	pins map[int]*simPin
+}
+
+type simPin struct {
+	sim    *Sim
+	number int
+
+	level  rpio.State
+	driven bool
+	detect rpio.Edge
+	edge   bool
+}
+
+func NewSim() *Sim {
+	return &Sim{pins: make(map[int]*simPin)}
+}
+
+func (s *Sim) Pin(number int) Pin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pinLocked(number)
+}
+
+func (s *Sim) pinLocked(number int) *simPin {
+	p, ok := s.pins[number]
+	if !ok {
+		p = &simPin{sim: s, number: number}
+		s.pins[number] = p
+	}
+	return p
+}
+
+func (s *Sim) Close() error {
+	return nil
+}
+
+// Set drives pin number to level, as the outside world would.
+func (s *Sim) Set(number int, level rpio.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pinLocked(number)
+	p.driven = true
+	p.setLocked(level)
+}
+
+// Levels returns the current level of every pin in use.
+func (s *Sim) Levels() map[int]rpio.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	levels := make(map[int]rpio.State, len(s.pins))
+	for n, p := range s.pins {
+		levels[n] = p.level
+	}
+	return levels
+}
+
+func (p *simPin) setLocked(level rpio.State) {
+	if level == p.level {
+		return
+	}
+	rising := level == rpio.High
+	if p.detect == rpio.AnyEdge ||
+		(p.detect == rpio.RiseEdge && rising) ||
+		(p.detect == rpio.FallEdge && !rising) {
+		p.edge = true
+	}
+	p.level = level
+}
+
+func (p *simPin) Input()  {}
+func (p *simPin) Output() {}
+
+// Pull sets the level an undriven pin floats to.
+func (p *simPin) Pull(pull rpio.Pull) {
+	p.sim.mu.Lock()
+	defer p.sim.mu.Unlock()
+	if p.driven {
+		return
+	}
+	if pull == rpio.PullUp {
+		p.setLocked(rpio.High)
+	} else {
+		p.setLocked(rpio.Low)
+	}
+}
+
+func (p *simPin) Read() rpio.State {
+	p.sim.mu.Lock()
+	defer p.sim.mu.Unlock()
+	return p.level
+}
+
+func (p *simPin) Write(level rpio.State) {
+	p.sim.mu.Lock()
+	defer p.sim.mu.Unlock()
+	p.setLocked(level)
+}
+
+func (p *simPin) Detect(edge rpio.Edge) {
+	p.sim.mu.Lock()
+	defer p.sim.mu.Unlock()
+	p.detect = edge
+	p.edge = false
+}
+
+func (p *simPin) EdgeDetected() bool {
+	p.sim.mu.Lock()
+	defer p.sim.mu.Unlock()
+	detected := p.edge
+	p.edge = false
+	return detected
+}
+
+// parseLevels reads lines of "<pin> <level>", blank lines and lines
+// starting with # are skipped.
+func parseLevels(r io.Reader) (map[int]rpio.State, error) {
+	levels := make(map[int]rpio.State)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want '<pin> <level>', got %q", line, scanner.Text())
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pin: %w", line, err)
+		}
+		level, err := ParseLevel(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		levels[n] = level
+	}
+	return levels, scanner.Err()
+}
+
+// WatchFile applies the levels in path every time the file changes, until
+// ctx is done. The file holds one "<pin> <level>" per line, so a door can be
+// opened with: echo "12 low" > levels.
+func (s *Sim) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	var modTime time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if fi, err := os.Stat(path); err != nil {
+			log.Println("Error reading simulated levels file:", err)
+		} else if !fi.ModTime().Equal(modTime) {
+			modTime = fi.ModTime()
+			if err := s.applyFile(path); err != nil {
+				log.Println("Error reading simulated levels file:", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sim) applyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	levels, err := parseLevels(f)
+	if err != nil {
+		return err
+	}
+	for n, level := range levels {
+		s.Set(n, level)
+	}
+	return nil
+}
+
+// Step drives Pin to Level After the previous step.
+type Step struct {
+	After time.Duration
+	Pin   int
+	Level rpio.State
+}
+
+// ParseScript reads a scripted sequence of steps, one "<delay> <pin>
+// <level>" per line, for example "30s 12 low". Blank lines and lines
+// starting with # are skipped.
+func ParseScript(r io.Reader) ([]Step, error) {
+	var steps []Step
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want '<delay> <pin> <level>', got %q", line, scanner.Text())
+		}
+		after, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid delay: %w", line, err)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pin: %w", line, err)
+		}
+		level, err := ParseLevel(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		steps = append(steps, Step{After: after, Pin: n, Level: level})
+	}
+	return steps, scanner.Err()
+}
+
+// Play runs steps in order, stopping early when ctx is done.
+func (s *Sim) Play(ctx context.Context, steps []Step) {
+	for _, step := range steps {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(step.After):
+			s.Set(step.Pin, step.Level)
+		}
+	}
+}
+
+// Handler is the HTTP control endpoint of the simulation:
+//
+//	GET /sim/pins                     current level of every pin
+//	PUT /sim/pins/{pin}?level=low     drive a pin
+func (s *Sim) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /sim/pins", func(w http.ResponseWriter, _ *http.Request) {
+		levels := make(map[string]string)
+		for n, level := range s.Levels() {
+			levels[strconv.Itoa(n)] = FormatLevel(level)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(levels); err != nil {
+			log.Println("Error replying simulated pins:", err)
+		}
+	})
+
+	mux.HandleFunc("PUT /sim/pins/{pin}", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.PathValue("pin"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid pin %q", r.PathValue("pin")), http.StatusBadRequest)
+			return
+		}
+		level, err := ParseLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.Set(n, level)
+		log.Printf("Simulated pin %d set %s", n, FormatLevel(level))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
@@ -0,0 +1,65 @@
+package gpio
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+func TestSim__PullSetsRestingLevelAndSetDetectsEdges(t *testing.T) {
+	t.Parallel()
+	sim := NewSim()
+	p := sim.Pin(12)
+	p.Input()
+	p.Pull(rpio.PullUp)
+	if got := p.Read(); got != rpio.High {
+		t.Fatalf("want pulled up pin high, got %v", got)
+	}
+
+	p.Detect(rpio.FallEdge)
+	sim.Set(12, rpio.High)
+	if p.EdgeDetected() {
+		t.Error("want no edge without a level change")
+	}
+	sim.Set(12, rpio.Low)
+	if !p.EdgeDetected() {
+		t.Error("want falling edge detected")
+	}
+	if p.EdgeDetected() {
+		t.Error("want edge cleared once read")
+	}
+}
+
+func TestParseScript__ParsesStepsAndRejectsBadLines(t *testing.T) {
+	t.Parallel()
+	steps, err := ParseScript(strings.NewReader("# open then close\n1s 12 low\n\n500ms 12 high\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Step{{time.Second, 12, rpio.Low}, {500 * time.Millisecond, 12, rpio.High}}
+	if len(steps) != len(want) {
+		t.Fatalf("want %d steps, got %d", len(want), len(steps))
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d: want %+v, got %+v", i, want[i], steps[i])
+		}
+	}
+
+	if _, err := ParseScript(strings.NewReader("1s 12 ajar\n")); err == nil {
+		t.Error("want error for invalid level, got nil")
+	}
+}
+
+func TestSim__PlayDrivesPins(t *testing.T) {
+	t.Parallel()
+	sim := NewSim()
+	sim.Play(context.Background(), []Step{{0, 5, rpio.High}, {time.Millisecond, 5, rpio.Low}, {0, 6, rpio.High}})
+	levels := sim.Levels()
+	if levels[5] != rpio.Low || levels[6] != rpio.High {
+		t.Errorf("want pin 5 low and 6 high, got %v", levels)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// health backs the Kubernetes probes. doorcheck is alive while the GPIO
+// backend is open and every door was read recently, and ready as long as it
+// is alive and not shutting down.
+type health struct {
+    backend string
+    doors []*door
+    // a door not read for this long means its monitor is stuck
+    staleAfter time.Duration
+
+    gpioOpen atomic.Bool
+    shuttingDown atomic.Bool
+}
+
+func newHealth(backend string, doors []*door, staleAfter time.Duration) *health {
+    return &health{
+        backend: backend,
+        doors: doors,
+        staleAfter: staleAfter,
+    }
+}
+
+type doorHealth struct {
+    Name string `json:"name"`
+    LastRead *time.Time `json:"last_read,omitempty"`
+    Stale bool `json:"stale"`
+}
+
+type healthReport struct {
+    Status string `json:"status"`
+    GPIO struct {
+        Backend string `json:"backend"`
+        Available bool `json:"available"`
+    } `json:"gpio"`
+    ShuttingDown bool `json:"shutting_down"`
+    Doors []doorHealth `json:"doors"`
+}
+
+// check reports the health at t, and whether doorcheck is alive and ready.
+func (h *health) check(t time.Time) (report healthReport, live, ready bool) {
+    report.GPIO.Backend = h.backend
+    report.GPIO.Available = h.gpioOpen.Load()
+    report.ShuttingDown = h.shuttingDown.Load()
+
+    live = report.GPIO.Available
+    for _, d := range h.doors {
+        dh := doorHealth{Name: d.name(), Stale: true}
+        if last, ok := d.lastReadAt(); ok {
+            dh.LastRead = &last
+            dh.Stale = t.Sub(last) > h.staleAfter
+        }
+        live = live && !dh.Stale
+        report.Doors = append(report.Doors, dh)
+    }
+    ready = live && !report.ShuttingDown
+    return report, live, ready
+}
+
+func healthHandler(h *health, readiness bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, _ *http.Request) {
+        report, live, ready := h.check(now())
+        ok := live
+        if readiness {
+            ok = ready
+        }
+
+        report.Status = "ok"
+        if !ok {
+            report.Status = "unavailable"
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+        writeJSON(w, report)
+    }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth__ReflectsGPIOAndSensorReads(t *testing.T) {
+	t.Parallel()
+	_, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	h := newHealth(gpioSim, doors, 3*time.Minute)
+
+	if _, live, _ := h.check(clock.Now()); live {
+		t.Error("want not alive before GPIO is open")
+	}
+	h.gpioOpen.Store(true)
+	if _, live, _ := h.check(clock.Now()); live {
+		t.Error("want not alive before the first read")
+	}
+
+	doors[0].read()
+	read, _ := doors[0].lastReadAt()
+	if report, live, ready := h.check(read.Add(time.Minute)); !live || !ready || report.Doors[0].Stale {
+		t.Errorf("want alive and ready after a recent read, got %+v", report)
+	}
+	if report, live, _ := h.check(read.Add(4 * time.Minute)); live || !report.Doors[0].Stale {
+		t.Errorf("want a stuck monitor reported, got %+v", report)
+	}
+}
+
+func TestHealthHandlers__NotReadyWhileShuttingDown(t *testing.T) {
+	t.Parallel()
+	_, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	h := newHealth(gpioSim, doors, 3*time.Minute)
+	h.gpioOpen.Store(true)
+	doors[0].read()
+	ts := httptest.NewServer(newMux(doors, nil, newEventHub(), nil, h, nil, nil))
+	defer ts.Close()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status("/healthz") != http.StatusOK || status("/readyz") != http.StatusOK {
+		t.Error("want healthy and ready")
+	}
+	h.shuttingDown.Store(true)
+	if got := status("/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("want 503 from /readyz while shutting down, got %d", got)
+	}
+	if got := status("/healthz"); got != http.StatusOK {
+		t.Errorf("want /healthz to stay OK while shutting down, got %d", got)
+	}
+}
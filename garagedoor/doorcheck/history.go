@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+const (
+    defaultHistoryLimit = 50
+    maxHistoryLimit = 500
+)
+
+// doorEvent is a single Open/Closed transition as stored in the event log.
+type doorEvent struct {
+    Door string `json:"door"`
+    Time time.Time `json:"time"`
+    DoorState sensor.State `json:"door_state"`
+    DoorStateText string `json:"door_state_text"`
+}
+
+// eventLog is an append-only, newline delimited JSON file of door
+// transitions. The whole log is also kept in memory: a garage door changes
+// state a handful of times per day, so this stays small for years.
+type eventLog struct {
+    mu sync.Mutex
+    f *os.File
+    events []doorEvent
+    subscribers []func(doorEvent)
+}
+
+func openEventLog(path string) (*eventLog, error) {
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+    if err != nil {
+        return nil, err
+    }
+
+    el := &eventLog{f: f}
+    scanner := bufio.NewScanner(f)
+    for line := 1; scanner.Scan(); line++ {
+        var e doorEvent
+        if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+            // A crash mid-write can leave a truncated last line behind
+            log.Printf("Skipping invalid history entry on line %d: %v", line, err)
+            continue
+        }
+        if e.Door == "" {
+            // written before doorcheck knew about more than one door
+            e.Door = defaultDoorName
+        }
+        el.events = append(el.events, e)
+    }
+    if err := scanner.Err(); err != nil {
+        f.Close()
+        return nil, fmt.Errorf("reading history file: %w", err)
+    }
+
+    return el, nil
+}
+
+// record appends a transition to the log if s differs from the last
+// recorded state of the door. It reports whether a transition was written.
+func (el *eventLog) record(door string, s sensor.State, t time.Time) (bool, error) {
+    el.mu.Lock()
+    defer el.mu.Unlock()
+
+    if last, ok := el.lastLocked(door); ok && last.DoorState == s {
+        return false, nil
+    }
+
+    e := doorEvent{
+        Door: door,
+        Time: t,
+        DoorState: s,
+        DoorStateText: fmt.Sprint(s),
+    }
+    b, err := json.Marshal(e)
+    if err != nil {
+        return false, err
+    }
+    if _, err := el.f.Write(append(b, '\n')); err != nil {
+        return false, err
+    }
+    if err := el.f.Sync(); err != nil {
+        return false, err
+    }
+    el.events = append(el.events, e)
+    for _, fn := range el.subscribers {
+        fn(e)
+    }
+    return true, nil
+}
+
+// subscribe calls fn with every transition recorded from now on. fn runs
+// with the log locked, so it must be quick and must not call back into it.
+func (el *eventLog) subscribe(fn func(doorEvent)) {
+    el.mu.Lock()
+    defer el.mu.Unlock()
+    el.subscribers = append(el.subscribers, fn)
+}
+
+// last returns the most recent transition of the door, if any.
+func (el *eventLog) last(door string) (doorEvent, bool) {
+    el.mu.Lock()
+    defer el.mu.Unlock()
+    return el.lastLocked(door)
+}
+
+func (el *eventLog) lastLocked(door string) (doorEvent, bool) {
+    for i := len(el.events) - 1; i >= 0; i-- {
+        if el.events[i].Door == door {
+            return el.events[i], true
+        }
+    }
+    return doorEvent{}, false
+}
+
+func (el *eventLog) Close() error {
+    el.mu.Lock()
+    defer el.mu.Unlock()
+    return el.f.Close()
+}
+
+// historyEntry is a transition as returned by the /history endpoint. Open
+// transitions carry how long the door stayed open.
+type historyEntry struct {
+    doorEvent
+    OpenSeconds *int64 `json:"open_seconds,omitempty"`
+    OpenDuration string `json:"open_duration,omitempty"`
+    StillOpen bool `json:"still_open,omitempty"`
+}
+
+// query returns the transitions of door between from and to (inclusive),
+// oldest first. An empty door matches every door. Open durations are
+// measured against the whole log, so an opening that started inside the
+// range but ended after it is still reported in full.
+func (el *eventLog) query(door string, from, to, now time.Time) []historyEntry {
+    el.mu.Lock()
+    defer el.mu.Unlock()
+
+    var entries []historyEntry
+    // index in entries of the open transition each door is waiting to close
+    pending := make(map[string]int)
+    for _, e := range el.events {
+        if door != "" && e.Door != door {
+            continue
+        }
+        if i, ok := pending[e.Door]; ok {
+            entries[i].setOpenUntil(e.Time, false)
+            delete(pending, e.Door)
+        }
+        if e.Time.Before(from) || e.Time.After(to) {
+            continue
+        }
+
+        entries = append(entries, historyEntry{doorEvent: e})
+        if e.DoorState == sensor.Open {
+            pending[e.Door] = len(entries) - 1
+        }
+    }
+    for _, i := range pending {
+        entries[i].setOpenUntil(now, true)
+    }
+    return entries
+}
+
+func (he *historyEntry) setOpenUntil(end time.Time, stillOpen bool) {
+    d := end.Sub(he.Time).Truncate(time.Second)
+    secs := int64(d.Seconds())
+    he.OpenSeconds = &secs
+    he.OpenDuration = d.String()
+    he.StillOpen = stillOpen
+}
+
+func parseHistoryQuery(r *http.Request, now time.Time) (from, to time.Time, limit, offset int, err error) {
+    q := r.URL.Query()
+
+    to = now
+    if v := q.Get("to"); v != "" {
+        if to, err = time.Parse(time.RFC3339, v); err != nil {
+            return from, to, 0, 0, fmt.Errorf("invalid 'to' time %q, must be RFC3339", v)
+        }
+    }
+    from = to.Add(-24 * time.Hour)
+    if v := q.Get("from"); v != "" {
+        if from, err = time.Parse(time.RFC3339, v); err != nil {
+            return from, to, 0, 0, fmt.Errorf("invalid 'from' time %q, must be RFC3339", v)
+        }
+    }
+    if to.Before(from) {
+        return from, to, 0, 0, errors.New("'from' must be before 'to'")
+    }
+
+    limit = defaultHistoryLimit
+    if v := q.Get("limit"); v != "" {
+        if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxHistoryLimit {
+            return from, to, 0, 0, fmt.Errorf("invalid limit %q, must be between 1 and %d", v, maxHistoryLimit)
+        }
+    }
+    if v := q.Get("offset"); v != "" {
+        if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+            return from, to, 0, 0, fmt.Errorf("invalid offset %q", v)
+        }
+    }
+    return from, to, limit, offset, nil
+}
+
+func historyHandler(hist *eventLog, doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        now := now()
+        from, to, limit, offset, err := parseHistoryQuery(r, now)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+
+        name := r.URL.Query().Get("door")
+        if name != "" && findDoor(doors, name) == nil {
+            http.Error(w, fmt.Sprintf("unknown door %q", name), http.StatusNotFound)
+            return
+        }
+
+        entries := hist.query(name, from, to, now)
+        total := len(entries)
+        page := []historyEntry{}
+        if offset < total {
+            page = entries[offset:min(offset+limit, total)]
+        }
+
+        response := struct {
+            From time.Time `json:"from"`
+            To time.Time `json:"to"`
+            Total int `json:"total"`
+            Offset int `json:"offset"`
+            Limit int `json:"limit"`
+            NextOffset *int `json:"next_offset,omitempty"`
+            Events []historyEntry `json:"events"`
+        } {
+            From: from,
+            To: to,
+            Total: total,
+            Offset: offset,
+            Limit: limit,
+            Events: page,
+        }
+        if next := offset + limit; next < total {
+            response.NextOffset = &next
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        if err := json.NewEncoder(w).Encode(response); err != nil {
+            log.Println("Error replying door history:", err)
+        }
+    }
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+func TestEventLog__RecordsOnlyTransitionsAndReloads(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	el, err := openEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 6, 4, 22, 0, 0, 0, time.UTC)
+	readings := []sensor.State{sensor.Closed, sensor.Closed, sensor.Open, sensor.Open, sensor.Closed}
+	for i, s := range readings {
+		if _, err := el.record("garage", s, start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := el.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	el, err = openEventLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.Close()
+	if got := len(el.events); got != 3 {
+		t.Fatalf("want 3 transitions after reload, got %d", got)
+	}
+}
+
+func TestEventLog__QueryReportsOpenDurations(t *testing.T) {
+	t.Parallel()
+	el, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.Close()
+
+	opened := time.Date(2024, 6, 4, 22, 0, 0, 0, time.UTC)
+	closed := opened.Add(9 * time.Hour)
+	reopened := closed.Add(time.Hour)
+	el.record("garage", sensor.Open, opened)
+	el.record("garage", sensor.Closed, closed)
+	el.record("garage", sensor.Open, reopened)
+
+	now := reopened.Add(5 * time.Minute)
+	got := el.query("", opened, opened.Add(time.Hour), now)
+	if len(got) != 1 {
+		t.Fatalf("want 1 entry in range, got %d", len(got))
+	}
+	if got[0].OpenSeconds == nil || *got[0].OpenSeconds != int64((9*time.Hour).Seconds()) {
+		t.Errorf("want open for 9h, got %+v", got[0])
+	}
+	if got[0].StillOpen {
+		t.Error("want door reported closed, got still open")
+	}
+
+	got = el.query("", reopened, now, now)
+	if len(got) != 1 || !got[0].StillOpen || *got[0].OpenSeconds != 300 {
+		t.Errorf("want door still open for 5m, got %+v", got)
+	}
+}
+
+func TestEventLog__TracksDoorsIndependently(t *testing.T) {
+	t.Parallel()
+	el, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer el.Close()
+
+	start := time.Date(2024, 6, 4, 22, 0, 0, 0, time.UTC)
+	el.record("bay1", sensor.Open, start)
+	el.record("side", sensor.Open, start.Add(time.Minute))
+	el.record("side", sensor.Closed, start.Add(2*time.Minute))
+	el.record("bay1", sensor.Closed, start.Add(time.Hour))
+
+	got := el.query("bay1", start, start.Add(2*time.Hour), start.Add(2*time.Hour))
+	if len(got) != 2 {
+		t.Fatalf("want 2 bay1 transitions, got %+v", got)
+	}
+	if *got[0].OpenSeconds != 3600 {
+		t.Errorf("want bay1 open for 1h, got %ds", *got[0].OpenSeconds)
+	}
+	if got := el.query("", start, start.Add(2*time.Hour), start.Add(2*time.Hour)); len(got) != 4 {
+		t.Errorf("want 4 transitions across doors, got %d", len(got))
+	}
+}
@@ -1,148 +1,144 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/stianeikeland/go-rpio/v4"
-)
+	"doorcheck/gpio"
+	"doorcheck/sensor"
 
-type state rpio.State
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
 
-func (s state) String() string {
-    if s == state(rpio.Low) {
-        return "Open"
+// how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// now is the clock used for night windows and alerting, tests replace it
+var now = time.Now
+
+// setupGPIO opens the configured backend and sets up every door with its
+// night schedule. sim is only set for the simulated backend.
+func setupGPIO(ctx context.Context, cfg *config, cal *calendar) (backend gpio.Backend, sim *gpio.Sim, doors []*door, err error) {
+    sun := newSunClock(cfg.Latitude, cfg.Longitude)
+    schedules := make([]*schedule, 0, len(cfg.Doors))
+    for _, dc := range cfg.Doors {
+        sched, err := newSchedule(nightWindow{dc.NightStart, dc.NightEnd}, dc.NightWeekdays, sun, cal)
+        if err != nil {
+            return nil, nil, nil, fmt.Errorf("door %s: %w", dc.Name, err)
+        }
+        schedules = append(schedules, sched)
     }
 
-    return "Closed"
-}
-
-func setupGPIO(pinNumber int) (rpio.Pin, error) {
-    if err := rpio.Open(); err != nil {
-        log.Println("Error opening GPIO:", err)
-        return 0, err
+    switch cfg.GPIO.Backend {
+    case gpioSim:
+        sim, err = newSim(ctx, cfg.GPIO)
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        backend = sim
+    default:
+        backend, err = gpio.OpenRPIO()
+        if err != nil {
+            log.Println("Error opening GPIO:", err)
+            return nil, nil, nil, err
+        }
     }
 
-    pin := rpio.Pin(pinNumber)
-
-    pin.Input()
-    rpio.PullMode(pin, rpio.PullUp)
-    return pin, nil
-}
-
-func getDoorState(pin rpio.Pin) state {
-    return state(pin.Read())
-}
-
-func isNight(start, end time.Time) bool {
-    cur := time.Now().Format("15:04")
-
-    now, err := time.Parse("15:04", cur)
-    if err != nil {
-        log.Println(err)
-        return false
-    }
-    if end.Before(start) {
-        end = end.Add(24*time.Hour)
+    doors = make([]*door, 0, len(cfg.Doors))
+    for i, dc := range cfg.Doors {
+        doors = append(doors, setupDoor(backend, dc, schedules[i]))
     }
-    if now.Before(start) {
-        now = now.Add(24 * time.Hour)
-    }
-
-    return now.After(start) && now.Before(end)
+    return backend, sim, doors, nil
 }
 
-func sendNotification(discordWebhook, message string) {
-    u, err := url.Parse(discordWebhook)
-    if err != nil {
-        log.Println("Invalid Discord webhook URL:", err)
-        return
-    }
-
-    v := url.Values{}
-    v.Set("wait", "true")
-    u.RawQuery = v.Encode()
+func newSim(ctx context.Context, cfg gpioConfig) (*gpio.Sim, error) {
+    sim := gpio.NewSim()
+    log.Println("Using simulated GPIO pins")
 
-    payload := struct {
-        Content string `json:"content"`
-    }{
-        Content: message,
+    if cfg.SimFile != "" {
+        go sim.WatchFile(ctx, cfg.SimFile, 500*time.Millisecond)
     }
-    
-    var body bytes.Buffer
-    if err := json.NewEncoder(&body).Encode(payload); err != nil {
-        log.Println("Error creating JSON payload:", err)
-        return
-    }
-
-    request, err := http.NewRequest(http.MethodPost, u.String(), &body)
-    if err != nil {
-        log.Println("Error creating Discord request:", err)
-        return
-    }
-    request.Header.Add("Content-Type", "application/json")
+    if cfg.SimScript != "" {
+        f, err := os.Open(cfg.SimScript)
+        if err != nil {
+            return nil, err
+        }
+        defer f.Close()
 
-    client := http.Client{
-        Timeout: 10 * time.Second,
+        steps, err := gpio.ParseScript(f)
+        if err != nil {
+            return nil, fmt.Errorf("parsing simulation script: %w", err)
+        }
+        go sim.Play(ctx, steps)
     }
+    return sim, nil
+}
 
-    response, err := client.Do(request)
-    if err != nil {
-        log.Println("Error sending Discord request:", err)
-        return
-    } else {
-        log.Println("Sent request to discord")
+func checkDoor(ctx context.Context, d *door, cfg *config, notifiers []Notifier, hist *eventLog, events *eventHub, snoozes *snoozes) {
+    // Polling always runs, in edge mode it catches any change the edge
+    // detection missed.
+    changes := make(chan sensor.State)
+    if cfg.MonitorMode == monitorEdge {
+        // The caller closes the GPIO backend once this returns, so wait for
+        // the pin to be released first.
+        var edges sync.WaitGroup
+        edges.Add(1)
+        defer edges.Wait()
+        go func() {
+            defer edges.Done()
+            watchEdges(ctx, d, cfg.Debounce, changes)
+        }()
     }
-
-    defer response.Body.Close()
-
-    if response.StatusCode != http.StatusOK {
-        log.Printf("invalid response from Discord channel: %s", response.Status)
-    } else {
-        log.Printf("Received a valid response from the Discord channel: %s", response.Status)
+    ticker := time.NewTicker(cfg.PollInterval)
+    defer ticker.Stop()
+
+    alerts := newAlerter(d.name(), cfg.Alerts, notifiers)
+    alerts.events = events
+    alerts.snoozes = snoozes
+    // Pick up an opening that started before a restart
+    if last, ok := hist.last(d.name()); ok && last.DoorState == sensor.Open {
+        alerts.openedAt = last.Time
     }
-}
 
-func checkDoor(pin rpio.Pin, cfg *config, discordWebhookURL string) {
-    for range time.Tick(1 * time.Minute) {
-        doorState := getDoorState(pin)
-        log.Println("Door state:", doorState)
+    for {
+        var doorState sensor.State
+        select {
+        case <-ctx.Done():
+            return
+        case doorState = <-changes:
+            log.Printf("Door %s state change detected: %s", d.name(), doorState)
+        case <-ticker.C:
+            doorState = d.read()
+            log.Printf("Door %s state: %s", d.name(), doorState)
+        }
 
-        if doorState == state(rpio.Low) {
-            if isNight(cfg.NightStart.t, cfg.NightEnd.t) {
-                message := fmt.Sprint(
-                    "Door open at night:", time.Now(),
-                )
-                log.Println(message)
-                go sendNotification(
-                    discordWebhookURL, message,
-                )
-            } else {
-                m := fmt.Sprint("Door is open at regular hour", time.Now())
-                log.Println(m)
-            }
-        } else if doorState == state(rpio.High) {
-            m := fmt.Sprint("Door is closed, no need to notification to discord")
-            log.Println(m)
+        if recordState(hist, d, doorState, now()) {
+            log.Printf("Door %s state changed to: %s", d.name(), doorState)
         }
-        log.Println("Will check and log door state again in a minute.")
+
+        alerts.update(doorState, d.isNight(), now())
+        log.Printf("Will check and log door %s state again in %s.", d.name(), cfg.PollInterval)
     }
 }
 
-func doorStateHandler(pin rpio.Pin) http.HandlerFunc {
+func doorStateHandler(d *door) http.HandlerFunc {
     return func(w http.ResponseWriter, _ *http.Request) {
-        doorState := getDoorState(pin)
+        doorState := d.read()
         log.Println("Door state:", doorState)
 
         response := struct {
-            DoorState state `json:"door_state"`
+            DoorState sensor.State `json:"door_state"`
             DoorStateText string `json:"door_state_text"`
         } {
             DoorState: doorState,
@@ -155,7 +151,9 @@ func doorStateHandler(pin rpio.Pin) http.HandlerFunc {
     }
 }
 
-func newMux(pin rpio.Pin) http.Handler {
+// newMux serves the API. The relay routes open the garage, they are only
+// served with an Authenticator, to keys with the control scope.
+func newMux(doors []*door, hist *eventLog, events *eventHub, snoozes *snoozes, health *health, sim *gpio.Sim, auth *httpauth.Authenticator) http.Handler {
     mux := http.NewServeMux()
 
     mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -165,7 +163,27 @@ func newMux(pin rpio.Pin) http.Handler {
             http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
         }
     })
-    mux.Handle("/getdoor", doorStateHandler(pin))
+    // /getdoor and /door/toggle predate multiple doors and act on the
+    // first configured one
+    mux.Handle("/getdoor", doorStateHandler(doors[0]))
+    if auth != nil {
+        mux.Handle("POST /door/toggle", auth.RequireFunc(httpauth.ScopeControl, toggleHandler(doors[0].relay)))
+        mux.Handle("POST /doors/{name}/toggle", auth.RequireFunc(httpauth.ScopeControl, doorToggleHandler(doors)))
+    }
+    mux.Handle("GET /doors", doorsHandler(doors))
+    mux.Handle("GET /doors/{name}", doorHandler(doors))
+    mux.Handle("GET /history", historyHandler(hist, doors))
+    mux.Handle("GET /events", eventsHandler(events, sseHeartbeat))
+    mux.Handle("GET /alerts/snooze", snoozeListHandler(snoozes))
+    mux.Handle("POST /alerts/snooze", snoozeHandler(snoozes, doors))
+    mux.Handle("DELETE /alerts/snooze", unsnoozeHandler(snoozes, doors))
+    mux.Handle("GET /schedule", scheduleHandler(doors))
+    mux.Handle("GET /healthz", healthHandler(health, false))
+    mux.Handle("GET /readyz", healthHandler(health, true))
+    mux.Handle("/metrics", promhttp.Handler())
+    if sim != nil {
+        mux.Handle("/sim/", sim.Handler())
+    }
     return mux
 }
 
@@ -173,38 +191,128 @@ func main() {
     c := flag.String("c", "config.yml", "Config file")
     flag.Parse()
 
-    cfg, err := newConfig(*c)
-    if err != nil {
-        log.Println("Error opening config file:", err)
+    if err := run(*c); err != nil {
+        log.Println(err)
         os.Exit(1)
     }
+}
 
-    discordWebhookURL, ok := os.LookupEnv("DISCORD_WEBHOOK_URL")
-    if ! ok {
-        log.Println("'DISCORD_WEBHOOK_URL' env is required")
-        os.Exit(1)
+// run serves doorcheck until SIGINT or SIGTERM, then shuts down cleanly:
+// the API stops taking requests, the monitors stop, pending notifications
+// are flushed and the GPIO backend is released.
+func run(configFile string) error {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    cfg, err := newConfig(configFile)
+    if err != nil {
+        return fmt.Errorf("opening config file: %w", err)
+    }
+
+    var notifiers []Notifier
+    for _, nc := range cfg.Notifiers {
+        n, err := newNotifier(nc)
+        if err != nil {
+            return fmt.Errorf("configuring notifier: %w", err)
+        }
+        notifiers = append(notifiers, n)
+    }
+
+    // No relay can be toggled without authentication, auth.disabled
+    // notwithstanding
+    var auth *httpauth.Authenticator
+    if cfg.Auth.SecretsFile != "" {
+        if auth, err = httpauth.Open(cfg.Auth); err != nil {
+            return fmt.Errorf("opening auth: %w", err)
+        }
+    } else if slices.ContainsFunc(cfg.Doors, func(dc doorConfig) bool { return dc.Relay.PinNumber != 0 }) {
+        log.Println("WARNING: no auth secrets_file configured, remote toggle is disabled")
+    }
+
+    var cal *calendar
+    if cfg.CalendarFile != "" {
+        if cal, err = openCalendar(cfg.CalendarFile); err != nil {
+            return fmt.Errorf("opening calendar: %w", err)
+        }
     }
 
-    pin, err := setupGPIO(cfg.SwitchPinNumber)
+    backend, sim, doors, err := setupGPIO(ctx, cfg, cal)
     if err != nil {
-        log.Println("Error opening GPIO:", err)
-        os.Exit(1)
+        return fmt.Errorf("opening GPIO: %w", err)
     }
+    health := newHealth(cfg.GPIO.Backend, doors, 3*cfg.PollInterval)
+    health.gpioOpen.Store(true)
+    defer func() {
+        health.gpioOpen.Store(false)
+        if err := backend.Close(); err != nil {
+            log.Println("Error closing GPIO:", err)
+        }
+    }()
 
-    defer rpio.Close()
+    hist, err := openEventLog(cfg.HistoryFile)
+    if err != nil {
+        return fmt.Errorf("opening history file: %w", err)
+    }
+    defer hist.Close()
 
-    go checkDoor(pin, cfg, discordWebhookURL)
+    snoozes, err := openSnoozes(cfg.SnoozeFile, notifiers)
+    if err != nil {
+        return fmt.Errorf("opening snooze file: %w", err)
+    }
+
+    events := newEventHub()
+    hist.subscribe(func(e doorEvent) {
+        events.publish(eventDoor, e)
+    })
+    for _, d := range doors {
+        recordState(hist, d, d.read(), now())
+    }
+    if cfg.MQTT.Broker != "" {
+        mp := newMQTTPublisher(cfg.MQTT, doors, hist)
+        defer mp.Close()
+    }
+
+    monitorCtx, stopMonitors := context.WithCancel(ctx)
+    defer stopMonitors()
+    var monitors sync.WaitGroup
+    for _, d := range doors {
+        monitors.Add(1)
+        go func() {
+            defer monitors.Done()
+            checkDoor(monitorCtx, d, cfg, notifiers, hist, events, snoozes)
+        }()
+    }
 
     s := &http.Server{
         Addr: ":3060",
-        Handler: newMux(pin),
+        Handler: newMux(doors, hist, events, snoozes, health, sim, auth),
         WriteTimeout: 10 * time.Second,
     }
+    s.RegisterOnShutdown(events.close)
+
+    serveErr := make(chan error, 1)
+    go func() {
+        log.Println("Starting API server on port: 3060")
+        serveErr <- s.ListenAndServe()
+    }()
+
+    select {
+    case err = <-serveErr:
+        err = fmt.Errorf("serving API: %w", err)
+    case <-ctx.Done():
+        log.Println("Shutting down")
+    }
+    health.shuttingDown.Store(true)
 
-    log.Println("Starting API server on port: 3060")
-    if err := s.ListenAndServe(); err != nil {
-        log.Println(err)
-        os.Exit(1)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    if err := s.Shutdown(shutdownCtx); err != nil {
+        log.Println("Error shutting down API server:", err)
     }
-    log.Println("API Server is up and running")
-}
\ No newline at end of file
+    stopMonitors()
+    monitors.Wait()
+    if !flushNotifications(notifyTimeout) {
+        log.Println("Gave up waiting for pending notifications")
+    }
+    return err
+}
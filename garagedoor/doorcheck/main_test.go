@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"doorcheck/gpio"
+	"doorcheck/sensor"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
+
+// clock drives now() for the whole package. Tests that Set it must not run
+// in parallel.
+var clock = &fakeClock{t: time.Date(2024, 6, 4, 12, 0, 0, 0, time.Local)}
+
+func TestMain(m *testing.M) {
+	now = clock.Now
+	os.Exit(m.Run())
+}
+
+func mustHour(t *testing.T, s string) yamlHour {
+	t.Helper()
+	h, err := time.Parse("3:04pm", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return yamlHour{t: h}
+}
+
+func newTestDoors(t *testing.T, dcs ...doorConfig) (*gpio.Sim, []*door) {
+	t.Helper()
+	sim := gpio.NewSim()
+	var doors []*door
+	for _, dc := range dcs {
+		if !dc.NightStart.isSet() {
+			dc.NightStart = mustHour(t, "9:00pm")
+			dc.NightEnd = mustHour(t, "7:00am")
+		}
+		if err := dc.validate(); err != nil {
+			t.Fatal(err)
+		}
+		sched, err := newSchedule(nightWindow{dc.NightStart, dc.NightEnd}, dc.NightWeekdays, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doors = append(doors, setupDoor(sim, dc, sched))
+	}
+	return sim, doors
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDoorStateHandlers__ReportSimulatedPins(t *testing.T) {
+	t.Parallel()
+	sim, doors := newTestDoors(t,
+		doorConfig{Name: "bay1", PinNumber: 12},
+		doorConfig{Name: "side", PinNumber: 21, Pull: "down", OpenWhen: "high"},
+	)
+	hist, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hist.Close()
+	ts := httptest.NewServer(newMux(doors, hist, newEventHub(), nil, nil, sim, nil))
+	defer ts.Close()
+
+	getStatus := func(path string) doorStatus {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var s doorStatus
+		if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// pulled up and pulled down respectively, both closed at rest
+	if got := getStatus("/getdoor").DoorStateText; got != "Closed" {
+		t.Errorf("want bay1 closed at rest, got %s", got)
+	}
+	if got := getStatus("/doors/side").DoorStateText; got != "Closed" {
+		t.Errorf("want side closed at rest, got %s", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/sim/pins/21?level=high", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("want 204 driving simulated pin, got %s", resp.Status)
+	}
+	if got := getStatus("/doors/side").DoorStateText; got != "Open" {
+		t.Errorf("want side open once pin 21 is high, got %s", got)
+	}
+
+	resp, err = http.Get(ts.URL + "/doors/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("want 404 for unknown door, got %s", resp.Status)
+	}
+}
+
+func TestCheckDoor__AlertsWhenLeftOpenAtNight(t *testing.T) {
+	start := time.Date(2024, 6, 4, 20, 0, 0, 0, time.Local)
+	clock.Set(start)
+
+	sim, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	hist, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hist.Close()
+
+	cfg := &config{PollInterval: 5 * time.Millisecond, MonitorMode: monitorEdge, Debounce: time.Millisecond}
+	cfg.Alerts.setDefaults()
+	n := make(chanNotifier, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checkDoor(ctx, doors[0], cfg, []Notifier{n}, hist, newEventHub(), nil)
+
+	// opened in the evening, no alert while it is still day
+	sim.Set(12, rpio.Low)
+	waitFor(t, func() bool {
+		last, ok := hist.last("bay1")
+		return ok && last.DoorState == sensor.Open
+	})
+	n.none(t)
+
+	clock.Set(start.Add(2 * time.Hour))
+	if m := n.next(t); !strings.HasPrefix(m, "[bay1] Door open at night, open for 2h") {
+		t.Errorf("want night alert, got %q", m)
+	}
+
+	clock.Set(start.Add(2*time.Hour + 10*time.Minute))
+	sim.Set(12, rpio.High)
+	if m := n.next(t); !strings.HasPrefix(m, "[bay1] Door closed after 2h10m") {
+		t.Errorf("want resolution, got %q", m)
+	}
+
+	got := hist.query("bay1", start, clock.Now(), clock.Now())
+	if len(got) != 2 || got[0].OpenDuration != "2h10m0s" {
+		t.Errorf("want an open and a close recorded 2h10m apart, got %+v", got)
+	}
+}
+
+// detectPin records the edge detection set on a pin.
+type detectPin struct {
+	gpio.Pin
+	mu    sync.Mutex
+	edges []rpio.Edge
+}
+
+func (p *detectPin) Detect(edge rpio.Edge) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.edges = append(p.edges, edge)
+	p.Pin.Detect(edge)
+}
+
+func (p *detectPin) detected() []rpio.Edge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]rpio.Edge(nil), p.edges...)
+}
+
+func TestCheckDoor__ReleasesEdgeDetectionBeforeReturning(t *testing.T) {
+	_, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	pin := &detectPin{Pin: doors[0].pin}
+	doors[0].pin = pin
+	hist, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hist.Close()
+
+	cfg := &config{PollInterval: time.Hour, MonitorMode: monitorEdge, Debounce: time.Millisecond}
+	cfg.Alerts.setDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checkDoor(ctx, doors[0], cfg, nil, hist, newEventHub(), nil)
+	}()
+	waitFor(t, func() bool { return len(pin.detected()) > 0 })
+
+	// the backend is closed as soon as the monitors return
+	cancel()
+	<-done
+	if got := pin.detected(); len(got) != 2 || got[1] != rpio.NoEdge {
+		t.Errorf("edge detection after checkDoor returned: %v, want it turned off", got)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"doorcheck/sensor"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+    doorOpenGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+        Name: "garage_door_open",
+        Help: "Current door state, 1 when open and 0 when closed.",
+    }, []string{"door"})
+    doorOpenEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "garage_door_open_events_total",
+        Help: "Number of times the door was opened.",
+    }, []string{"door"})
+    doorOpenDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name: "garage_door_open_duration_seconds",
+        Help: "How long the door stayed open, observed when it closes.",
+        Buckets: []float64{30, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 43200},
+    }, []string{"door"})
+
+    notificationAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "garage_door_notification_attempts_total",
+        Help: "Notifications attempted, by channel.",
+    }, []string{"channel"})
+    notificationSuccesses = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "garage_door_notification_successes_total",
+        Help: "Notifications delivered, by channel.",
+    }, []string{"channel"})
+    notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "garage_door_notification_failures_total",
+        Help: "Notifications that failed to deliver, by channel.",
+    }, []string{"channel"})
+)
+
+// recordState stores a reading of the door in the history and the metrics.
+// It reports whether the door changed state.
+func recordState(hist *eventLog, d *door, s sensor.State, t time.Time) bool {
+    prev, hadPrev := hist.last(d.name())
+    changed, err := hist.record(d.name(), s, t)
+    if err != nil {
+        log.Println("Error recording door history:", err)
+        return false
+    }
+
+    isOpen := s == sensor.Open
+    if isOpen {
+        doorOpenGauge.WithLabelValues(d.name()).Set(1)
+    } else {
+        doorOpenGauge.WithLabelValues(d.name()).Set(0)
+    }
+    if !changed {
+        return false
+    }
+
+    if isOpen {
+        doorOpenEvents.WithLabelValues(d.name()).Inc()
+    } else if hadPrev && prev.DoorState == sensor.Open {
+        doorOpenDuration.WithLabelValues(d.name()).Observe(t.Sub(prev.Time).Seconds())
+    }
+    return true
+}
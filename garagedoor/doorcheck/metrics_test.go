@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The metrics are global, the counters are checked from what they were
+// before each test.
+
+func openDurations(t *testing.T, door string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := doorOpenDuration.WithLabelValues(door).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestRecordState__UpdatesDoorMetrics(t *testing.T) {
+	t.Parallel()
+	_, doors := newTestDoors(t,
+		doorConfig{Name: "metrics1", PinNumber: 12},
+		doorConfig{Name: "metrics2", PinNumber: 13},
+	)
+	hist, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hist.Close()
+
+	opened := func(door string) float64 {
+		return testutil.ToFloat64(doorOpenEvents.WithLabelValues(door))
+	}
+	before := map[string]float64{"metrics1": opened("metrics1"), "metrics2": opened("metrics2")}
+	count1, sum1 := openDurations(t, "metrics1")
+	count2, _ := openDurations(t, "metrics2")
+
+	check := func(step, door string, open, opens float64) {
+		t.Helper()
+		if got := testutil.ToFloat64(doorOpenGauge.WithLabelValues(door)); got != open {
+			t.Errorf("%s: %s gauge = %v, want %v", step, door, got, open)
+		}
+		if got := opened(door) - before[door]; got != opens {
+			t.Errorf("%s: %s opened %v times, want %v", step, door, got, opens)
+		}
+	}
+
+	start := time.Date(2024, 6, 4, 12, 0, 0, 0, time.Local)
+	recordState(hist, doors[0], sensor.Closed, start)
+	recordState(hist, doors[1], sensor.Closed, start)
+	check("startup", "metrics1", 0, 0)
+
+	recordState(hist, doors[0], sensor.Open, start.Add(time.Minute))
+	check("opened", "metrics1", 1, 1)
+	check("other door", "metrics2", 0, 0)
+
+	// still open, not another opening
+	recordState(hist, doors[0], sensor.Open, start.Add(2*time.Minute))
+	check("still open", "metrics1", 1, 1)
+
+	recordState(hist, doors[0], sensor.Closed, start.Add(11*time.Minute))
+	check("closed", "metrics1", 0, 1)
+
+	if n, sum := openDurations(t, "metrics1"); n-count1 != 1 || sum-sum1 != 600 {
+		t.Errorf("open duration: %d observations summing to %vs, want one of 600s", n-count1, sum-sum1)
+	}
+	if n, _ := openDurations(t, "metrics2"); n != count2 {
+		t.Errorf("open duration of a door never opened: %d observations", n-count2)
+	}
+}
+
+type stubNotifier struct {
+	name string
+	err  error
+}
+
+func (n stubNotifier) Name() string { return n.name }
+
+func (n stubNotifier) Notify(context.Context, string) error { return n.err }
+
+func TestSendNotification__CountsByChannel(t *testing.T) {
+	t.Parallel()
+	// attempts, successes and failures
+	counts := func(channel string) [3]float64 {
+		return [3]float64{
+			testutil.ToFloat64(notificationAttempts.WithLabelValues(channel)),
+			testutil.ToFloat64(notificationSuccesses.WithLabelValues(channel)),
+			testutil.ToFloat64(notificationFailures.WithLabelValues(channel)),
+		}
+	}
+	ok, down := counts("metrics-ok"), counts("metrics-down")
+
+	sendNotification([]Notifier{
+		stubNotifier{name: "metrics-ok"},
+		stubNotifier{name: "metrics-down", err: errors.New("unreachable")},
+	}, "door open")
+
+	waitFor(t, func() bool {
+		return counts("metrics-ok") == [3]float64{ok[0] + 1, ok[1] + 1, ok[2]} &&
+			counts("metrics-down") == [3]float64{down[0] + 1, down[1], down[2] + 1}
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"doorcheck/sensor"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+const (
+    monitorPoll = "poll"
+    monitorEdge = "edge"
+
+    // how often the edge detect register is checked for a new event
+    edgeCheckInterval = 10 * time.Millisecond
+)
+
+// watchEdges sends the door state on changes as soon as the reed switch
+// settles on a new value. Any edge seen during the debounce window restarts
+// it, so contact bounce never produces more than one change.
+func watchEdges(ctx context.Context, d *door, debounce time.Duration, changes chan<- sensor.State) {
+    d.pin.Detect(rpio.AnyEdge)
+    defer d.pin.Detect(rpio.NoEdge)
+
+    ticker := time.NewTicker(edgeCheckInterval)
+    defer ticker.Stop()
+
+    last := d.read()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+        if !d.pin.EdgeDetected() {
+            continue
+        }
+
+        // a floating pin may never settle
+        for settled := false; !settled; {
+            select {
+            case <-ctx.Done():
+                return
+            case <-time.After(debounce):
+            }
+            settled = !d.pin.EdgeDetected()
+        }
+
+        if cur := d.read(); cur != last {
+            last = cur
+            select {
+            case changes <- cur:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }
+}
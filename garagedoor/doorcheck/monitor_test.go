@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"doorcheck/gpio"
+	"doorcheck/sensor"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// bounce toggles a pin every millisecond for n times, ending at level.
+func bounce(sim *gpio.Sim, pin int, level rpio.State, n int) {
+	other := rpio.High
+	if level == rpio.High {
+		other = rpio.Low
+	}
+	for i := n; i > 0; i-- {
+		if i%2 == 0 {
+			sim.Set(pin, other)
+		} else {
+			sim.Set(pin, level)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sim.Set(pin, level)
+}
+
+func TestWatchEdges__DebouncesToOneChange(t *testing.T) {
+	sim, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	changes := make(chan sensor.State, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchEdges(ctx, doors[0], 30*time.Millisecond, changes)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// let the watcher read the closed door first
+	time.Sleep(2 * edgeCheckInterval)
+
+	bounce(sim, 12, rpio.Low, 20)
+	select {
+	case s := <-changes:
+		if s != sensor.Open {
+			t.Fatalf("got %s, want open", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change after the door opened")
+	}
+	select {
+	case s := <-changes:
+		t.Errorf("got a second change %s from one bounce", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// contact bounce that settles back where it was is no change
+	bounce(sim, 12, rpio.Low, 21)
+	select {
+	case s := <-changes:
+		t.Errorf("got change %s from a bounce back to open", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchEdges__StopsOnAPinThatNeverSettles(t *testing.T) {
+	sim, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12, Pull: "off"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopFloating := make(chan struct{})
+	defer close(stopFloating)
+	go func() {
+		level := rpio.Low
+		for {
+			select {
+			case <-stopFloating:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			level ^= 1
+			sim.Set(12, level)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchEdges(ctx, doors[0], 20*time.Millisecond, make(chan sensor.State))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchEdges did not stop on a floating pin")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"doorcheck/sensor"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+    mqttOnline = "online"
+    mqttOffline = "offline"
+    mqttPublishTimeout = 5 * time.Second
+)
+
+type mqttConfig struct {
+    // broker URL, e.g. tcp://mosquitto:1883. MQTT is off when empty.
+    Broker string `yaml:"broker"`
+    ClientID string `yaml:"client_id"`
+    Username string `yaml:"username"`
+    Password string `yaml:"password"`
+    // door state is published to <topic_prefix>/<door>/state
+    TopicPrefix string `yaml:"topic_prefix"`
+    // publish Home Assistant discovery config under discovery_prefix
+    Discovery bool `yaml:"discovery"`
+    DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
+
+func (mc *mqttConfig) setDefaults() {
+    if mc.ClientID == "" {
+        mc.ClientID = "doorcheck"
+    }
+    if mc.TopicPrefix == "" {
+        mc.TopicPrefix = "doorcheck"
+    }
+    mc.TopicPrefix = strings.TrimSuffix(mc.TopicPrefix, "/")
+    if mc.DiscoveryPrefix == "" {
+        mc.DiscoveryPrefix = "homeassistant"
+    }
+    mc.DiscoveryPrefix = strings.TrimSuffix(mc.DiscoveryPrefix, "/")
+}
+
+// mqttPublisher keeps the retained state of every door up to date on the
+// broker and publishes each transition as an event. Discovery and state
+// are published again on every (re)connect.
+type mqttPublisher struct {
+    cfg mqttConfig
+    doors []*door
+    hist *eventLog
+    client mqtt.Client
+}
+
+// newMQTTPublisher starts connecting to the broker and keeps retrying in
+// the background, doorcheck works without it.
+func newMQTTPublisher(cfg mqttConfig, doors []*door, hist *eventLog) *mqttPublisher {
+    mp := &mqttPublisher{cfg: cfg, doors: doors, hist: hist}
+
+    opts := mqtt.NewClientOptions().
+        AddBroker(os.ExpandEnv(cfg.Broker)).
+        SetClientID(cfg.ClientID).
+        SetUsername(os.ExpandEnv(cfg.Username)).
+        SetPassword(os.ExpandEnv(cfg.Password)).
+        SetWill(mp.availabilityTopic(), mqttOffline, 1, true).
+        SetConnectRetry(true).
+        SetAutoReconnect(true).
+        SetOnConnectHandler(mp.onConnect).
+        SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+            log.Println("Lost connection to MQTT broker:", err)
+        })
+    mp.client = mqtt.NewClient(opts)
+    mp.client.Connect()
+    hist.subscribe(mp.publishEvent)
+    return mp
+}
+
+func (mp *mqttPublisher) availabilityTopic() string {
+    return mp.cfg.TopicPrefix + "/status"
+}
+
+func (mp *mqttPublisher) stateTopic(door string) string {
+    return mp.cfg.TopicPrefix + "/" + door + "/state"
+}
+
+func (mp *mqttPublisher) eventsTopic(door string) string {
+    return mp.cfg.TopicPrefix + "/" + door + "/events"
+}
+
+func (mp *mqttPublisher) discoveryTopic(door string) string {
+    return mp.cfg.DiscoveryPrefix + "/binary_sensor/" + mp.cfg.ClientID + "/" + door + "/config"
+}
+
+func statePayload(s sensor.State) string {
+    if s == sensor.Open {
+        return "open"
+    }
+    return "closed"
+}
+
+// haDiscovery is a Home Assistant MQTT discovery payload for a binary_sensor.
+type haDiscovery struct {
+    Name string `json:"name"`
+    UniqueID string `json:"unique_id"`
+    DeviceClass string `json:"device_class"`
+    StateTopic string `json:"state_topic"`
+    PayloadOn string `json:"payload_on"`
+    PayloadOff string `json:"payload_off"`
+    AvailabilityTopic string `json:"availability_topic"`
+    Device haDevice `json:"device"`
+}
+
+type haDevice struct {
+    Identifiers []string `json:"identifiers"`
+    Name string `json:"name"`
+}
+
+func (mp *mqttPublisher) onConnect(_ mqtt.Client) {
+    log.Println("Connected to MQTT broker")
+    mp.publish(mp.availabilityTopic(), true, mqttOnline)
+
+    for _, d := range mp.doors {
+        if mp.cfg.Discovery {
+            b, err := json.Marshal(haDiscovery{
+                Name: d.name(),
+                UniqueID: mp.cfg.ClientID + "_" + d.name(),
+                DeviceClass: "garage_door",
+                StateTopic: mp.stateTopic(d.name()),
+                PayloadOn: statePayload(sensor.Open),
+                PayloadOff: statePayload(sensor.Closed),
+                AvailabilityTopic: mp.availabilityTopic(),
+                Device: haDevice{
+                    Identifiers: []string{mp.cfg.ClientID},
+                    Name: mp.cfg.ClientID,
+                },
+            })
+            if err != nil {
+                log.Println("Error encoding discovery payload:", err)
+                continue
+            }
+            mp.publish(mp.discoveryTopic(d.name()), true, b)
+        }
+        if last, ok := mp.hist.last(d.name()); ok {
+            mp.publish(mp.stateTopic(d.name()), true, statePayload(last.DoorState))
+        }
+    }
+}
+
+// publishEvent is subscribed to the event log and sees every transition.
+func (mp *mqttPublisher) publishEvent(e doorEvent) {
+    mp.publish(mp.stateTopic(e.Door), true, statePayload(e.DoorState))
+
+    b, err := json.Marshal(e)
+    if err != nil {
+        log.Println("Error encoding MQTT event:", err)
+        return
+    }
+    mp.publish(mp.eventsTopic(e.Door), false, b)
+}
+
+// publish sends at QoS 1 without blocking the caller. Messages published
+// while disconnected are dropped, the retained state is refreshed on
+// reconnect.
+func (mp *mqttPublisher) publish(topic string, retained bool, payload any) {
+    if !mp.client.IsConnectionOpen() {
+        return
+    }
+    token := mp.client.Publish(topic, 1, retained, payload)
+    go func() {
+        if !token.WaitTimeout(mqttPublishTimeout) {
+            log.Printf("Timed out publishing to %s", topic)
+        } else if err := token.Error(); err != nil {
+            log.Printf("Error publishing to %s: %v", topic, err)
+        }
+    }()
+}
+
+// Close marks doorcheck offline and disconnects.
+func (mp *mqttPublisher) Close() {
+    if mp.client.IsConnectionOpen() {
+        mp.client.Publish(mp.availabilityTopic(), 1, true, mqttOffline).WaitTimeout(mqttPublishTimeout)
+    }
+    mp.client.Disconnect(250)
+}
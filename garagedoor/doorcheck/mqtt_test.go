@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// startBroker runs an embedded broker and returns it with its address.
+func startBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	server := mochi.New(&mochi.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return server, "tcp://" + tcp.Address()
+}
+
+type mqttMessage struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+// inbox collects every message published under a filter.
+type inbox struct {
+	msgs    chan mqttMessage
+	pending []mqttMessage
+}
+
+func subscribe(t *testing.T, broker, filter string) *inbox {
+	t.Helper()
+	in := &inbox{msgs: make(chan mqttMessage, 100)}
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID("test-" + t.Name()))
+	if tok := c.Connect(); !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatalf("connecting subscriber: %v", tok.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(0) })
+	tok := c.Subscribe(filter, 1, func(_ mqtt.Client, m mqtt.Message) {
+		in.msgs <- mqttMessage{m.Topic(), string(m.Payload()), m.Retained()}
+	})
+	if !tok.WaitTimeout(time.Second) || tok.Error() != nil {
+		t.Fatalf("subscribing: %v", tok.Error())
+	}
+	return in
+}
+
+// next returns the oldest message on topic not returned yet. Retained
+// messages come in no particular order, so others are kept for later.
+func (in *inbox) next(t *testing.T, topic string) mqttMessage {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for i := 0; ; i++ {
+		for ; i < len(in.pending); i++ {
+			if m := in.pending[i]; m.topic == topic {
+				in.pending = append(in.pending[:i], in.pending[i+1:]...)
+				return m
+			}
+		}
+		select {
+		case m := <-in.msgs:
+			in.pending = append(in.pending, m)
+			i--
+		case <-timeout:
+			t.Fatalf("no message on %s", topic)
+		}
+	}
+}
+
+func TestMQTTPublisher__PublishesDiscoveryStateAndEvents(t *testing.T) {
+	t.Parallel()
+	server, broker := startBroker(t)
+	sim, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	hist, err := openEventLog(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hist.Close()
+	if _, err := hist.record("bay1", doors[0].read(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := mqttConfig{Broker: broker, Discovery: true}
+	cfg.setDefaults()
+	mp := newMQTTPublisher(cfg, doors, hist)
+	defer mp.Close()
+
+	// availability, discovery and state, published before the subscriber
+	// connected so only seen if retained
+	waitFor(t, func() bool { return len(server.Topics.Messages("#")) == 3 })
+	in := subscribe(t, broker, "#")
+
+	disc := in.next(t, "homeassistant/binary_sensor/doorcheck/bay1/config")
+	var d haDiscovery
+	if err := json.Unmarshal([]byte(disc.payload), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !disc.retained || d.DeviceClass != "garage_door" || d.StateTopic != "doorcheck/bay1/state" || d.UniqueID != "doorcheck_bay1" {
+		t.Errorf("unexpected discovery payload %+v (retained %v)", d, disc.retained)
+	}
+	if m := in.next(t, "doorcheck/bay1/state"); m.payload != "closed" || !m.retained {
+		t.Errorf("want retained closed state, got %+v", m)
+	}
+	if m := in.next(t, "doorcheck/status"); m.payload != mqttOnline {
+		t.Errorf("want online availability, got %+v", m)
+	}
+
+	sim.Set(12, rpio.Low)
+	if _, err := hist.record("bay1", doors[0].read(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if m := in.next(t, "doorcheck/bay1/state"); m.payload != "open" {
+		t.Errorf("want open state after transition, got %+v", m)
+	}
+	var e doorEvent
+	if err := json.Unmarshal([]byte(in.next(t, "doorcheck/bay1/events").payload), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Door != "bay1" || e.DoorState != sensor.Open {
+		t.Errorf("want bay1 open event, got %+v", e)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+    notifierDiscord = "discord"
+    notifierWebhook = "webhook"
+    notifierNtfy = "ntfy"
+    notifierGotify = "gotify"
+    notifierSMTP = "smtp"
+    notifierSlack = "slack"
+
+    notificationTitle = "Garage door"
+    notifyTimeout = 10 * time.Second
+)
+
+var errNotifierConfig = errors.New("invalid notifier config")
+
+// Notifier delivers an alert message to a single channel.
+type Notifier interface {
+    // Name identifies the channel in logs and metrics
+    Name() string
+    Notify(ctx context.Context, message string) error
+}
+
+// notifierConfig is one entry of the notifiers list in config.yml. Which
+// fields apply depends on Type. URLs, tokens, headers and credentials may
+// reference environment variables as $VAR or ${VAR}.
+type notifierConfig struct {
+    Type string `yaml:"type"`
+    Name string `yaml:"name"`
+    URL string `yaml:"url"`
+    Token string `yaml:"token"`
+    Headers map[string]string `yaml:"headers"`
+    Title string `yaml:"title"`
+    Priority int `yaml:"priority"`
+    Host string `yaml:"host"`
+    Port int `yaml:"port"`
+    Username string `yaml:"username"`
+    Password string `yaml:"password"`
+    From string `yaml:"from"`
+    To []string `yaml:"to"`
+}
+
+func newNotifier(nc notifierConfig) (Notifier, error) {
+    name := nc.Name
+    if name == "" {
+        name = nc.Type
+    }
+    rawURL := os.ExpandEnv(nc.URL)
+    token := os.ExpandEnv(nc.Token)
+    title := nc.Title
+    if title == "" {
+        title = notificationTitle
+    }
+    client := &http.Client{Timeout: notifyTimeout}
+
+    if nc.Type != notifierSMTP {
+        if _, err := url.ParseRequestURI(rawURL); err != nil {
+            return nil, fmt.Errorf("%w: %s: url: %v", errNotifierConfig, name, err)
+        }
+    }
+
+    switch nc.Type {
+    case notifierDiscord:
+        return &discordNotifier{name: name, url: rawURL, client: client}, nil
+    case notifierSlack:
+        return &slackNotifier{name: name, url: rawURL, client: client}, nil
+    case notifierWebhook:
+        headers := make(map[string]string, len(nc.Headers))
+        for k, v := range nc.Headers {
+            headers[k] = os.ExpandEnv(v)
+        }
+        return &webhookNotifier{name: name, url: rawURL, headers: headers, client: client}, nil
+    case notifierNtfy:
+        return &ntfyNotifier{name: name, url: rawURL, token: token, title: title, priority: nc.Priority, client: client}, nil
+    case notifierGotify:
+        if token == "" {
+            return nil, fmt.Errorf("%w: %s: gotify needs an application token", errNotifierConfig, name)
+        }
+        return &gotifyNotifier{name: name, url: rawURL, token: token, title: title, priority: nc.Priority, client: client}, nil
+    case notifierSMTP:
+        if nc.Host == "" || nc.From == "" || len(nc.To) == 0 {
+            return nil, fmt.Errorf("%w: %s: smtp needs host, from and to", errNotifierConfig, name)
+        }
+        port := nc.Port
+        if port == 0 {
+            port = 587
+        }
+        return &smtpNotifier{
+            name: name,
+            addr: net.JoinHostPort(nc.Host, strconv.Itoa(port)),
+            host: nc.Host,
+            username: os.ExpandEnv(nc.Username),
+            password: os.ExpandEnv(nc.Password),
+            from: nc.From,
+            to: nc.To,
+            subject: title,
+        }, nil
+    default:
+        return nil, fmt.Errorf("%w: %s: unknown type %q", errNotifierConfig, name, nc.Type)
+    }
+}
+
+func postJSON(ctx context.Context, client *http.Client, u string, headers map[string]string, payload any) error {
+    var body bytes.Buffer
+    if err := json.NewEncoder(&body).Encode(payload); err != nil {
+        return fmt.Errorf("creating JSON payload: %w", err)
+    }
+
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &body)
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Content-Type", "application/json")
+    for k, v := range headers {
+        request.Header.Set(k, v)
+    }
+    return do(client, request)
+}
+
+func do(client *http.Client, request *http.Request) error {
+    response, err := client.Do(request)
+    if err != nil {
+        return err
+    }
+    defer response.Body.Close()
+    io.Copy(io.Discard, response.Body)
+
+    if response.StatusCode < 200 || response.StatusCode > 299 {
+        return fmt.Errorf("invalid response: %s", response.Status)
+    }
+    return nil
+}
+
+type discordNotifier struct {
+    name string
+    url string
+    client *http.Client
+}
+
+func (n *discordNotifier) Name() string { return n.name }
+
+func (n *discordNotifier) Notify(ctx context.Context, message string) error {
+    u, err := url.Parse(n.url)
+    if err != nil {
+        return fmt.Errorf("invalid Discord webhook URL: %w", err)
+    }
+    v := u.Query()
+    v.Set("wait", "true")
+    u.RawQuery = v.Encode()
+
+    payload := struct {
+        Content string `json:"content"`
+    }{
+        Content: message,
+    }
+    return postJSON(ctx, n.client, u.String(), nil, payload)
+}
+
+// slackNotifier posts to a Slack incoming webhook, or anything accepting
+// the same payload (Mattermost, Rocket.Chat).
+type slackNotifier struct {
+    name string
+    url string
+    client *http.Client
+}
+
+func (n *slackNotifier) Name() string { return n.name }
+
+func (n *slackNotifier) Notify(ctx context.Context, message string) error {
+    payload := struct {
+        Text string `json:"text"`
+    }{
+        Text: message,
+    }
+    return postJSON(ctx, n.client, n.url, nil, payload)
+}
+
+// webhookNotifier posts a small JSON document to an arbitrary URL.
+type webhookNotifier struct {
+    name string
+    url string
+    headers map[string]string
+    client *http.Client
+}
+
+func (n *webhookNotifier) Name() string { return n.name }
+
+func (n *webhookNotifier) Notify(ctx context.Context, message string) error {
+    payload := struct {
+        Source string `json:"source"`
+        Message string `json:"message"`
+        Time time.Time `json:"time"`
+    }{
+        Source: "doorcheck",
+        Message: message,
+        Time: time.Now(),
+    }
+    return postJSON(ctx, n.client, n.url, n.headers, payload)
+}
+
+// ntfyNotifier publishes to an ntfy topic, url is the full topic URL.
+type ntfyNotifier struct {
+    name string
+    url string
+    token string
+    title string
+    priority int
+    client *http.Client
+}
+
+func (n *ntfyNotifier) Name() string { return n.name }
+
+func (n *ntfyNotifier) Notify(ctx context.Context, message string) error {
+    request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, strings.NewReader(message))
+    if err != nil {
+        return err
+    }
+    request.Header.Set("Title", n.title)
+    request.Header.Set("Tags", "door")
+    if n.priority != 0 {
+        request.Header.Set("Priority", strconv.Itoa(n.priority))
+    }
+    if n.token != "" {
+        request.Header.Set("Authorization", "Bearer "+n.token)
+    }
+    return do(n.client, request)
+}
+
+// gotifyNotifier sends to a Gotify server, url is the server base URL.
+type gotifyNotifier struct {
+    name string
+    url string
+    token string
+    title string
+    priority int
+    client *http.Client
+}
+
+func (n *gotifyNotifier) Name() string { return n.name }
+
+func (n *gotifyNotifier) Notify(ctx context.Context, message string) error {
+    payload := struct {
+        Title string `json:"title"`
+        Message string `json:"message"`
+        Priority int `json:"priority,omitempty"`
+    }{
+        Title: n.title,
+        Message: message,
+        Priority: n.priority,
+    }
+    headers := map[string]string{"X-Gotify-Key": n.token}
+    return postJSON(ctx, n.client, strings.TrimSuffix(n.url, "/")+"/message", headers, payload)
+}
+
+type smtpNotifier struct {
+    name string
+    addr string
+    host string
+    username string
+    password string
+    from string
+    to []string
+    subject string
+}
+
+func (n *smtpNotifier) Name() string { return n.name }
+
+func (n *smtpNotifier) Notify(ctx context.Context, message string) error {
+    var auth smtp.Auth
+    if n.username != "" {
+        auth = smtp.PlainAuth("", n.username, n.password, n.host)
+    }
+
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+    fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+    fmt.Fprintf(&msg, "Subject: %s\r\n", n.subject)
+    fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    fmt.Fprint(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+    fmt.Fprintf(&msg, "%s\r\n", message)
+
+    return sendMail(ctx, n.addr, n.host, auth, n.from, n.to, msg.Bytes())
+}
+
+// sendMail is smtp.SendMail bounded by ctx: the connection is dialed with
+// it, given its deadline and closed when it is done, so a server that stops
+// answering cannot hold the notification up.
+func sendMail(ctx context.Context, addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+    conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        if err := conn.SetDeadline(deadline); err != nil {
+            return err
+        }
+    }
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    c, err := smtp.NewClient(conn, host)
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+            return err
+        }
+    }
+    if auth != nil {
+        if ok, _ := c.Extension("AUTH"); !ok {
+            return errors.New("smtp: server doesn't support AUTH")
+        }
+        if err := c.Auth(auth); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(from); err != nil {
+        return err
+    }
+    for _, addr := range to {
+        if err := c.Rcpt(addr); err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+// sendNotification fans message out to every configured notifier
+// concurrently. Failures are logged, one channel failing does not stop the
+// others.
+func sendNotification(notifiers []Notifier, message string) {
+    for _, n := range notifiers {
+        pendingNotifications.Add(1)
+        go func() {
+            defer pendingNotifications.Done()
+            ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+            defer cancel()
+
+            notificationAttempts.WithLabelValues(n.Name()).Inc()
+            if err := n.Notify(ctx, message); err != nil {
+                notificationFailures.WithLabelValues(n.Name()).Inc()
+                log.Printf("Error sending notification to %s: %v", n.Name(), err)
+                return
+            }
+            notificationSuccesses.WithLabelValues(n.Name()).Inc()
+            log.Printf("Sent notification to %s", n.Name())
+        }()
+    }
+}
+
+// pendingNotifications tracks the notifications still being sent, so they
+// can be flushed on shutdown.
+var pendingNotifications sync.WaitGroup
+
+// flushNotifications waits up to timeout for pending notifications. It
+// reports whether they all went out.
+func flushNotifications(timeout time.Duration) bool {
+    done := make(chan struct{})
+    go func() {
+        pendingNotifications.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return true
+    case <-time.After(timeout):
+        return false
+    }
+}
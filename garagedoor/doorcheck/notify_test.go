@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type capturedRequest struct {
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+func newCaptureServer(t *testing.T, status int) (*httptest.Server, <-chan capturedRequest) {
+	t.Helper()
+	reqs := make(chan capturedRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		reqs <- capturedRequest{
+			path:   r.URL.Path,
+			query:  r.URL.RawQuery,
+			header: r.Header.Clone(),
+			body:   body,
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, reqs
+}
+
+func jsonField(t *testing.T, body []byte, field string) any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", body, err)
+	}
+	return m[field]
+}
+
+func TestNotifiers__SendExpectedRequests(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		nc    notifierConfig
+		path  string
+		check func(t *testing.T, r capturedRequest)
+	}{
+		{
+			name: "discord",
+			nc:   notifierConfig{Type: notifierDiscord},
+			path: "/",
+			check: func(t *testing.T, r capturedRequest) {
+				if r.query != "wait=true" {
+					t.Errorf("want wait=true query, got %q", r.query)
+				}
+				if got := jsonField(t, r.body, "content"); got != "door open" {
+					t.Errorf("want content 'door open', got %v", got)
+				}
+			},
+		},
+		{
+			name: "slack",
+			nc:   notifierConfig{Type: notifierSlack},
+			path: "/",
+			check: func(t *testing.T, r capturedRequest) {
+				if got := jsonField(t, r.body, "text"); got != "door open" {
+					t.Errorf("want text 'door open', got %v", got)
+				}
+			},
+		},
+		{
+			name: "webhook",
+			nc: notifierConfig{
+				Type:    notifierWebhook,
+				Headers: map[string]string{"Authorization": "Bearer secret"},
+			},
+			path: "/",
+			check: func(t *testing.T, r capturedRequest) {
+				if got := r.header.Get("Authorization"); got != "Bearer secret" {
+					t.Errorf("want configured header sent, got %q", got)
+				}
+				if got := jsonField(t, r.body, "message"); got != "door open" {
+					t.Errorf("want message 'door open', got %v", got)
+				}
+			},
+		},
+		{
+			name: "ntfy",
+			nc:   notifierConfig{Type: notifierNtfy, Token: "tk", Priority: 4},
+			path: "/",
+			check: func(t *testing.T, r capturedRequest) {
+				if string(r.body) != "door open" {
+					t.Errorf("want plain text body, got %q", r.body)
+				}
+				if got := r.header.Get("Priority"); got != "4" {
+					t.Errorf("want priority 4, got %q", got)
+				}
+				if got := r.header.Get("Authorization"); got != "Bearer tk" {
+					t.Errorf("want bearer token, got %q", got)
+				}
+			},
+		},
+		{
+			name: "gotify",
+			nc:   notifierConfig{Type: notifierGotify, Token: "app-token"},
+			path: "/message",
+			check: func(t *testing.T, r capturedRequest) {
+				if got := r.header.Get("X-Gotify-Key"); got != "app-token" {
+					t.Errorf("want app token header, got %q", got)
+				}
+				if got := jsonField(t, r.body, "title"); got != notificationTitle {
+					t.Errorf("want default title, got %v", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ts, reqs := newCaptureServer(t, http.StatusOK)
+			tt.nc.URL = ts.URL
+			n, err := newNotifier(tt.nc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := n.Notify(context.Background(), "door open"); err != nil {
+				t.Fatal(err)
+			}
+			r := <-reqs
+			if r.path != tt.path {
+				t.Errorf("want request to %q, got %q", tt.path, r.path)
+			}
+			tt.check(t, r)
+		})
+	}
+}
+
+func TestNotifiers__ReturnErrorOnFailedDelivery(t *testing.T) {
+	t.Parallel()
+	ts, _ := newCaptureServer(t, http.StatusInternalServerError)
+	n, err := newNotifier(notifierConfig{Type: notifierSlack, URL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), "door open"); err == nil {
+		t.Fatal("want error on 500 response, got nil")
+	}
+}
+
+func TestNewNotifier__RejectsUnknownType(t *testing.T) {
+	t.Parallel()
+	if _, err := newNotifier(notifierConfig{Type: "pager", URL: "http://x"}); err == nil {
+		t.Fatal("want error for unknown notifier type, got nil")
+	}
+}
+
+// newSMTPServer answers SMTP without extensions, sending the message of
+// every mail to the channel, or greets and then says nothing when stuck.
+func newSMTPServer(t *testing.T, stuck bool) (host string, port int, mails <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if stuck {
+					io.Copy(io.Discard, conn)
+					return
+				}
+				r := bufio.NewReader(conn)
+				reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+					case "EHLO", "HELO", "MAIL", "RCPT":
+						reply("250 OK")
+					case "DATA":
+						reply("354 go ahead")
+						var msg strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							msg.WriteString(line)
+						}
+						ch <- msg.String()
+						reply("250 OK")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("502 unknown")
+					}
+				}
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestSMTPNotifier__Sends(t *testing.T) {
+	t.Parallel()
+	host, port, mails := newSMTPServer(t, false)
+	n, err := newNotifier(notifierConfig{Type: notifierSMTP, Host: host, Port: port, From: "door@example.com", To: []string{"me@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Notify(ctx, "door open"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-mails; !strings.Contains(msg, "To: me@example.com\r\n") || !strings.HasSuffix(msg, "\r\n\r\ndoor open\r\n") {
+		t.Errorf("sent %q", msg)
+	}
+}
+
+func TestSMTPNotifier__GivesUpWithTheContext(t *testing.T) {
+	t.Parallel()
+	host, port, _ := newSMTPServer(t, true)
+	n, err := newNotifier(notifierConfig{Type: notifierSMTP, Host: host, Port: port, From: "door@example.com", To: []string{"me@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with a deadline, and cancelled without one
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancelNow)
+	for name, ctx := range map[string]context.Context{"deadline": ctx, "cancelled": cancelled} {
+		done := make(chan error, 1)
+		go func() { done <- n.Notify(ctx, "door open") }()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s: want an error from a server that does not answer", name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: notification still pending on a server that does not answer", name)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"doorcheck/gpio"
+	"doorcheck/sensor"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+const relaySampleInterval = 10 * time.Millisecond
+
+type relayConfig struct {
+    // GPIO pin driving the opener relay, 0 disables remote toggling
+    PinNumber int `yaml:"pin_number"`
+    // most relay boards switch on when their input is pulled low
+    ActiveLow bool `yaml:"active_low"`
+    // how long the relay is held, like a press of the wall button
+    Pulse time.Duration `yaml:"pulse"`
+    // minimum time between two pulses
+    Cooldown time.Duration `yaml:"cooldown"`
+    // how long to wait for the sensor to report the new state
+    ConfirmTimeout time.Duration `yaml:"confirm_timeout"`
+    // the sensor has to read the same value for this long before a pulse
+    StableFor time.Duration `yaml:"stable_for"`
+}
+
+func (rc *relayConfig) setDefaults() {
+    if rc.Pulse == 0 {
+        rc.Pulse = 500 * time.Millisecond
+    }
+    if rc.Cooldown == 0 {
+        rc.Cooldown = 30 * time.Second
+    }
+    if rc.ConfirmTimeout == 0 {
+        rc.ConfirmTimeout = 30 * time.Second
+    }
+    if rc.StableFor == 0 {
+        rc.StableFor = time.Second
+    }
+}
+
+// relay pulses the garage door opener and confirms the result on the
+// door's sensor. Only one toggle runs at a time.
+type relay struct {
+    cfg relayConfig
+    pin gpio.Pin
+    door *door
+
+    mu sync.Mutex
+    lastPulse time.Time
+}
+
+func setupRelay(cfg relayConfig, d *door, pin gpio.Pin) *relay {
+    r := &relay{
+        cfg: cfg,
+        pin: pin,
+        door: d,
+    }
+    r.pin.Output()
+    r.pin.Write(r.level(false))
+    return r
+}
+
+func (r *relay) level(active bool) rpio.State {
+    if active != r.cfg.ActiveLow {
+        return rpio.High
+    }
+    return rpio.Low
+}
+
+// stableState samples the sensor over the configured window and reports
+// whether every reading agreed.
+func (r *relay) stableState() (sensor.State, bool) {
+    first := r.door.read()
+    deadline := time.Now().Add(r.cfg.StableFor)
+    for time.Now().Before(deadline) {
+        time.Sleep(relaySampleInterval)
+        if r.door.read() != first {
+            return first, false
+        }
+    }
+    return first, true
+}
+
+func (r *relay) pulse() {
+    r.pin.Write(r.level(true))
+    time.Sleep(r.cfg.Pulse)
+    r.pin.Write(r.level(false))
+}
+
+// waitForChange polls the sensor until it leaves from, or the confirm
+// timeout expires.
+func (r *relay) waitForChange(from sensor.State) (sensor.State, bool) {
+    deadline := time.Now().Add(r.cfg.ConfirmTimeout)
+    for time.Now().Before(deadline) {
+        if cur := r.door.read(); cur != from {
+            return cur, true
+        }
+        time.Sleep(100 * time.Millisecond)
+    }
+    return from, false
+}
+
+func toggleHandler(r *relay) http.HandlerFunc {
+    return func(w http.ResponseWriter, _ *http.Request) {
+        if r == nil {
+            http.Error(w, "remote toggle is not configured", http.StatusNotImplemented)
+            return
+        }
+        if !r.mu.TryLock() {
+            http.Error(w, "a toggle is already in progress", http.StatusConflict)
+            return
+        }
+        defer r.mu.Unlock()
+
+        if wait := r.cfg.Cooldown - time.Since(r.lastPulse); wait > 0 {
+            w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            http.Error(w, fmt.Sprintf("relay cooling down, retry in %s", wait.Round(time.Second)), http.StatusTooManyRequests)
+            return
+        }
+
+        // Confirming can take longer than the server's write timeout
+        budget := r.cfg.StableFor + r.cfg.Pulse + r.cfg.ConfirmTimeout + 5*time.Second
+        if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(budget)); err != nil {
+            log.Println("Error extending write deadline:", err)
+        }
+
+        before, stable := r.stableState()
+        if !stable {
+            log.Printf("Refusing to toggle door %s, sensor reading is unstable", r.door.name())
+            http.Error(w, "sensor reading is unstable, refusing to toggle", http.StatusConflict)
+            return
+        }
+
+        log.Printf("Toggling door %s, currently %s", r.door.name(), before)
+        start := time.Now()
+        r.lastPulse = start
+        r.pulse()
+        after, confirmed := r.waitForChange(before)
+        elapsed := time.Since(start).Round(time.Millisecond)
+
+        response := struct {
+            Name string `json:"name"`
+            Success bool `json:"success"`
+            PreviousState string `json:"previous_state"`
+            DoorState sensor.State `json:"door_state"`
+            DoorStateText string `json:"door_state_text"`
+            Elapsed string `json:"elapsed"`
+        } {
+            Name: r.door.name(),
+            Success: confirmed,
+            PreviousState: fmt.Sprint(before),
+            DoorState: after,
+            DoorStateText: fmt.Sprint(after),
+            Elapsed: elapsed.String(),
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        if confirmed {
+            log.Printf("Door %s toggled to %s after %s", r.door.name(), after, elapsed)
+        } else {
+            log.Printf("Door %s still %s %s after toggling", r.door.name(), after, elapsed)
+            w.WriteHeader(http.StatusGatewayTimeout)
+        }
+        if err := json.NewEncoder(w).Encode(response); err != nil {
+            log.Println("Error replying toggle result:", err)
+        }
+    }
+}
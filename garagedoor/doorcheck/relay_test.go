@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"doorcheck/gpio"
+
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+type toggleResult struct {
+	Success       bool   `json:"success"`
+	PreviousState string `json:"previous_state"`
+	DoorStateText string `json:"door_state_text"`
+}
+
+func newRelayServer(t *testing.T, relay relayConfig) (*gpio.Sim, *httptest.Server) {
+	t.Helper()
+	sim, doors := newTestDoors(t,
+		doorConfig{Name: "bay1", PinNumber: 12, Relay: relay},
+		doorConfig{Name: "bay2", PinNumber: 13},
+	)
+	mux := http.NewServeMux()
+	mux.Handle("POST /doors/{name}/toggle", doorToggleHandler(doors))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return sim, ts
+}
+
+func toggle(t *testing.T, ts *httptest.Server, name string) (*http.Response, toggleResult) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/doors/"+name+"/toggle", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res toggleResult
+	if resp.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, res
+}
+
+// pulsed returns a channel closed once the relay on pin is driven high, as
+// the opener would see it.
+func pulsed(sim *gpio.Sim, pin int, stop <-chan struct{}) <-chan struct{} {
+	seen := make(chan struct{})
+	go func() {
+		for sim.Levels()[pin] != rpio.High {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+		close(seen)
+	}()
+	return seen
+}
+
+func TestToggleHandler__ConfirmsAndCoolsDown(t *testing.T) {
+	t.Parallel()
+	sim, ts := newRelayServer(t, relayConfig{
+		PinNumber:      20,
+		Pulse:          10 * time.Millisecond,
+		Cooldown:       time.Minute,
+		ConfirmTimeout: time.Second,
+		StableFor:      20 * time.Millisecond,
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	// the opener starts the door moving when the relay closes
+	seen := pulsed(sim, 20, stop)
+	go func() {
+		select {
+		case <-seen:
+			sim.Set(12, rpio.Low)
+		case <-stop:
+		}
+	}()
+
+	resp, res := toggle(t, ts, "bay1")
+	if resp.StatusCode != http.StatusOK || !res.Success || res.PreviousState != "Closed" || res.DoorStateText != "Open" {
+		t.Fatalf("toggle: got %s %+v, want a confirmed change from closed to open", resp.Status, res)
+	}
+	if sim.Levels()[20] != rpio.Low {
+		t.Error("relay left closed after the pulse")
+	}
+
+	resp, _ = toggle(t, ts, "bay1")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("toggle during cooldown: got %s, Retry-After %q, want 429 and 60", resp.Status, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestToggleHandler__RefusesUnstableReading(t *testing.T) {
+	t.Parallel()
+	sim, ts := newRelayServer(t, relayConfig{PinNumber: 20, StableFor: 100 * time.Millisecond})
+	stop := make(chan struct{})
+	defer close(stop)
+	seen := pulsed(sim, 20, stop)
+	// the door is moving, the reed switch flickers
+	go func() {
+		level := rpio.High
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			level ^= 1
+			sim.Set(12, level)
+		}
+	}()
+
+	if resp, _ := toggle(t, ts, "bay1"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("toggle: got %s, want 409", resp.Status)
+	}
+	select {
+	case <-seen:
+		t.Error("relay pulsed on an unstable reading")
+	default:
+	}
+}
+
+func TestToggleHandler__TimesOutWithoutChange(t *testing.T) {
+	t.Parallel()
+	_, ts := newRelayServer(t, relayConfig{
+		PinNumber:      20,
+		Pulse:          10 * time.Millisecond,
+		ConfirmTimeout: 200 * time.Millisecond,
+		StableFor:      20 * time.Millisecond,
+	})
+
+	// nothing moves the door
+	resp, res := toggle(t, ts, "bay1")
+	if resp.StatusCode != http.StatusGatewayTimeout || res.Success || res.DoorStateText != "Closed" {
+		t.Errorf("toggle: got %s %+v, want 504 with the door still closed", resp.Status, res)
+	}
+}
+
+func TestToggleHandler__NotConfigured(t *testing.T) {
+	t.Parallel()
+	_, ts := newRelayServer(t, relayConfig{PinNumber: 20})
+
+	if resp, _ := toggle(t, ts, "bay2"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("toggle without a relay: got %s, want 501", resp.Status)
+	}
+	if resp, _ := toggle(t, ts, "bay3"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("toggle of an unknown door: got %s, want 404", resp.Status)
+	}
+}
+
+func TestNewMux__ToggleNeedsAuthentication(t *testing.T) {
+	t.Parallel()
+	const (
+		controlSecret = "0123456789abcdef-control"
+		readSecret    = "0123456789abcdef-read"
+	)
+	sim, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12, Relay: relayConfig{PinNumber: 20}})
+	auth, err := httpauth.New([]httpauth.Key{
+		{Name: "home-assistant", Secret: controlSecret, Scopes: []string{httpauth.ScopeControl}},
+		{Name: "dashboard", Secret: readSecret, Scopes: []string{httpauth.ScopeRead}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.SetAuditLog(io.Discard)
+
+	post := func(ts *httptest.Server, path, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// without keys the relay is never served
+	open := httptest.NewServer(newMux(doors, nil, newEventHub(), nil, nil, nil, nil))
+	defer open.Close()
+	for _, path := range []string{"/door/toggle", "/doors/bay1/toggle"} {
+		if code := post(open, path, ""); code != http.StatusMethodNotAllowed {
+			t.Errorf("%s without auth: got %d, want 405", path, code)
+		}
+	}
+
+	ts := httptest.NewServer(newMux(doors, nil, newEventHub(), nil, nil, nil, auth))
+	defer ts.Close()
+	for _, path := range []string{"/door/toggle", "/doors/bay1/toggle"} {
+		if code := post(ts, path, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s with no key: got %d, want 401", path, code)
+		}
+		if code := post(ts, path, readSecret); code != http.StatusForbidden {
+			t.Errorf("%s with a read key: got %d, want 403", path, code)
+		}
+	}
+	if sim.Levels()[20] != rpio.Low {
+		t.Fatal("relay pulsed without a control key")
+	}
+	if code := post(ts, "/doors/bay2/toggle", controlSecret); code != http.StatusNotFound {
+		t.Errorf("unknown door with a control key: got %d, want 404", code)
+	}
+}
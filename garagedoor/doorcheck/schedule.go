@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// nightWindow is when night starts and ends, End being on the next day
+// when it is earlier than Start.
+type nightWindow struct {
+    Start yamlHour `yaml:"start"`
+    End yamlHour `yaml:"end"`
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+    for d := time.Sunday; d <= time.Saturday; d++ {
+        name := strings.ToLower(d.String())
+        if s = strings.ToLower(s); s == name || s == name[:3] {
+            return d, nil
+        }
+    }
+    return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// schedule decides when it is night for a door: a calendar override when
+// one covers the time, else the window of the weekday the night started
+// on, else the default window.
+type schedule struct {
+    window nightWindow
+    weekdays map[time.Weekday]nightWindow
+    sun *sunClock
+    cal *calendar
+}
+
+// newSchedule fills in the parts of the weekday windows left out from the
+// default window.
+func newSchedule(window nightWindow, weekdays map[string]nightWindow, sun *sunClock, cal *calendar) (*schedule, error) {
+    s := &schedule{
+        window: window,
+        weekdays: make(map[time.Weekday]nightWindow),
+        sun: sun,
+        cal: cal,
+    }
+    for name, w := range weekdays {
+        day, err := parseWeekday(name)
+        if err != nil {
+            return nil, err
+        }
+        if !w.Start.isSet() {
+            w.Start = window.Start
+        }
+        if !w.End.isSet() {
+            w.End = window.End
+        }
+        s.weekdays[day] = w
+    }
+    return s, nil
+}
+
+// hours returns every time of day the schedule uses.
+func (s *schedule) hours() []yamlHour {
+    hours := []yamlHour{s.window.Start, s.window.End}
+    for _, w := range s.weekdays {
+        hours = append(hours, w.Start, w.End)
+    }
+    return hours
+}
+
+// nightOf returns the window for the night starting on the day of t, and
+// whether it comes from the weekday schedule.
+func (s *schedule) nightOf(t time.Time) (start, end time.Time, weekday bool) {
+    w, weekday := s.weekdays[t.Weekday()]
+    if !weekday {
+        w = s.window
+    }
+    start, end = w.Start.on(t, s.sun), w.End.on(t, s.sun)
+    if !end.After(start) {
+        end = end.AddDate(0, 0, 1)
+    }
+    return start, end, weekday
+}
+
+// scheduleStatus is what the schedule says about a point in time.
+type scheduleStatus struct {
+    Door string `json:"door"`
+    At time.Time `json:"at"`
+    Night bool `json:"night"`
+    // calendar, weekday or default
+    Source string `json:"source"`
+    Override *override `json:"override,omitempty"`
+    // the night window in effect, or the next one during the day
+    NightStart time.Time `json:"night_start"`
+    NightEnd time.Time `json:"night_end"`
+}
+
+func (s *schedule) at(t time.Time) scheduleStatus {
+    st := scheduleStatus{At: t}
+
+    // A night started yesterday may still be going on
+    yStart, yEnd, yWeekday := s.nightOf(t.AddDate(0, 0, -1))
+    start, end, weekday := s.nightOf(t)
+    switch {
+    case t.Before(yEnd) && !t.Before(yStart):
+        st.Night, st.NightStart, st.NightEnd, weekday = true, yStart, yEnd, yWeekday
+    case t.Before(end) && !t.Before(start):
+        st.Night, st.NightStart, st.NightEnd = true, start, end
+    default:
+        st.NightStart, st.NightEnd = start, end
+        if !t.Before(end) {
+            st.NightStart, st.NightEnd, weekday = s.nightOf(t.AddDate(0, 0, 1))
+        }
+    }
+    st.Source = "default"
+    if weekday {
+        st.Source = "weekday"
+    }
+
+    if o, ok := s.cal.at(t); ok {
+        st.Source = "calendar"
+        st.Override = &o
+        st.Night = o.Mode == overrideNight
+    }
+    return st
+}
+
+func (s *schedule) isNight(t time.Time) bool {
+    return s.at(t).Night
+}
+
+// scheduleHandler reports what the schedule of each door says now, or at
+// ?at= (RFC3339), for every door or just ?door=.
+func scheduleHandler(doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        at := now()
+        if v := r.URL.Query().Get("at"); v != "" {
+            var err error
+            if at, err = time.Parse(time.RFC3339, v); err != nil {
+                http.Error(w, fmt.Sprintf("invalid 'at' time %q, must be RFC3339", v), http.StatusBadRequest)
+                return
+            }
+            at = at.In(now().Location())
+        }
+
+        name := r.URL.Query().Get("door")
+        if name != "" && findDoor(doors, name) == nil {
+            http.Error(w, fmt.Sprintf("unknown door %q", name), http.StatusNotFound)
+            return
+        }
+
+        statuses := []scheduleStatus{}
+        for _, d := range doors {
+            if name != "" && d.name() != name {
+                continue
+            }
+            st := d.schedule.at(at)
+            st.Door = d.name()
+            statuses = append(statuses, st)
+        }
+        writeJSON(w, statuses)
+    }
+}
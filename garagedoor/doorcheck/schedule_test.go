@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSchedule__NightWindows(t *testing.T) {
+	t.Parallel()
+	sched, err := newSchedule(
+		nightWindow{mustHour(t, "9:00pm"), mustHour(t, "7:00am")},
+		map[string]nightWindow{
+			"friday": {Start: mustHour(t, "11:00pm"), End: mustHour(t, "9:00am")},
+			"sat":    {End: mustHour(t, "9:00am")},
+		},
+		nil, nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameDay, err := newSchedule(nightWindow{mustHour(t, "1:00pm"), mustHour(t, "5:00pm")}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-06-04 is a Tuesday, 2024-06-07 a Friday
+	day := func(d, h int) time.Time { return time.Date(2024, 6, d, h, 0, 0, 0, time.Local) }
+	tests := []struct {
+		name   string
+		sched  *schedule
+		at     time.Time
+		want   bool
+		source string
+	}{
+		{"before midnight inside overnight window", sched, day(4, 23), true, "default"},
+		{"after midnight inside overnight window", sched, day(5, 3), true, "default"},
+		{"daytime outside overnight window", sched, day(5, 12), false, "default"},
+		{"inside same day window", sameDay, day(5, 14), true, "default"},
+		{"outside same day window", sameDay, day(5, 18), false, "default"},
+		{"friday evening before the later weekday start", sched, day(7, 22), false, "weekday"},
+		{"saturday morning still in friday's night", sched, day(8, 8), true, "weekday"},
+		{"saturday evening with the default start", sched, day(8, 21), true, "weekday"},
+		{"sunday morning after saturday's night", sched, day(9, 8), true, "weekday"},
+		{"sunday noon", sched, day(9, 12), false, "default"},
+	}
+	for _, tt := range tests {
+		st := tt.sched.at(tt.at)
+		if st.Night != tt.want || st.Source != tt.source {
+			t.Errorf("%s: at %s got night %v from %s, want %v from %s",
+				tt.name, tt.at.Format("Mon 3PM"), st.Night, st.Source, tt.want, tt.source)
+		}
+	}
+
+	if _, err := newSchedule(nightWindow{}, map[string]nightWindow{"someday": {}}, nil, nil); err == nil {
+		t.Error("want an error for an unknown weekday")
+	}
+}
+
+func TestParseICS(t *testing.T) {
+	t.Parallel()
+	f, err := os.Open("testdata/overrides.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	overrides, err := parseICS(f, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 3 {
+		t.Fatalf("want 3 overrides, got %d", len(overrides))
+	}
+
+	want := []override{
+		{"Vacation, alert all day", overrideNight, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 8, 0, 0, 0, 0, time.UTC)},
+		{"Garage party, door open late on purpose", overrideDay, time.Date(2024, 7, 4, 18, 0, 0, 0, time.UTC), time.Date(2024, 7, 5, 2, 0, 0, 0, time.UTC)},
+		{"Movers", overrideDay, time.Date(2024, 7, 20, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 21, 0, 0, 0, 0, time.UTC)},
+	}
+	for i, o := range overrides {
+		w := want[i]
+		if o.Summary != w.Summary || o.Mode != w.Mode || !o.Start.Equal(w.Start) || !o.End.Equal(w.End) {
+			t.Errorf("override %d: got %+v, want %+v", i, o, w)
+		}
+	}
+}
+
+func TestScheduleHandler__ReportsCalendarOverrides(t *testing.T) {
+	t.Parallel()
+	cal, err := openCalendar("testdata/overrides.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12})
+	doors[0].schedule.cal = cal
+	ts := httptest.NewServer(newMux(doors, nil, newEventHub(), nil, nil, nil, nil))
+	defer ts.Close()
+
+	get := func(at time.Time) scheduleStatus {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/schedule?door=bay1&at=" + at.Format(time.RFC3339))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var statuses []scheduleStatus
+		if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != 1 {
+			t.Fatalf("want the status of bay1, got %+v", statuses)
+		}
+		return statuses[0]
+	}
+
+	// all-day events are in local time
+	noon := time.Date(2024, 7, 2, 12, 0, 0, 0, time.Local)
+	if st := get(noon); !st.Night || st.Source != "calendar" || st.Override.Summary != "Vacation, alert all day" {
+		t.Errorf("want vacation treated as night at noon, got %+v", st)
+	}
+	if st := get(time.Date(2024, 7, 4, 23, 0, 0, 0, time.UTC)); st.Night || st.Override.Mode != overrideDay {
+		t.Errorf("want the party to win over the vacation, got %+v", st)
+	}
+	if st := get(time.Date(2024, 7, 10, 12, 0, 0, 0, time.Local)); st.Night || st.Source != "default" {
+		t.Errorf("want the default window after the vacation, got %+v", st)
+	}
+}
@@ -0,0 +1,53 @@
+// Package sensor holds the door sensor types shared by doorcheck and the
+// magnetic diagnostic tool.
+package sensor
+
+import (
+	"fmt"
+
+	"doorcheck/gpio"
+
+	"github.com/stianeikeland/go-rpio/v4"
+)
+
+// State is a reading of a door reed switch. The wiring is normalised so
+// Open is always the low level.
+type State rpio.State
+
+const (
+	Open   = State(rpio.Low)
+	Closed = State(rpio.High)
+)
+
+func (s State) String() string {
+	if s == Open {
+		return "Open"
+	}
+
+	return "Closed"
+}
+
+var pullModes = map[string]rpio.Pull{
+	"up":   rpio.PullUp,
+	"down": rpio.PullDown,
+	"off":  rpio.PullOff,
+}
+
+// ParsePull parses a pull mode name: up, down or off.
+func ParsePull(s string) (rpio.Pull, error) {
+	p, ok := pullModes[s]
+	if !ok {
+		return rpio.PullOff, fmt.Errorf("invalid pull %q, must be up, down or off", s)
+	}
+	return p, nil
+}
+
+// Read returns the state of the door sensor on pin. openWhen is the pin
+// level read while the door is open, rpio.Low for a reed switch to ground
+// with a pull up.
+func Read(pin gpio.Pin, openWhen rpio.State) State {
+	if pin.Read() == openWhen {
+		return Open
+	}
+	return Closed
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// longest snooze accepted, a forgotten snooze must not silence alerts for
+// good
+const maxSnooze = 7 * 24 * time.Hour
+
+// snooze silences alerts for a door, or every door when Door is empty.
+type snooze struct {
+    Door string `json:"door,omitempty"`
+    Until time.Time `json:"until"`
+    By string `json:"by"`
+}
+
+func (s snooze) target() string {
+    if s.Door == "" {
+        return "all doors"
+    }
+    return s.Door
+}
+
+// snoozes are the active snoozes, saved to a JSON file on every change so
+// they survive restarts. Changes are announced on the notifiers.
+type snoozes struct {
+    mu sync.Mutex
+    path string
+    notifiers []Notifier
+    // by door, "" for the snooze covering every door
+    active map[string]snooze
+}
+
+func openSnoozes(path string, notifiers []Notifier) (*snoozes, error) {
+    sn := &snoozes{
+        path: path,
+        notifiers: notifiers,
+        active: make(map[string]snooze),
+    }
+    b, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return sn, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var saved []snooze
+    if err := json.Unmarshal(b, &saved); err != nil {
+        return nil, fmt.Errorf("reading snooze file: %w", err)
+    }
+    for _, s := range saved {
+        if s.Until.After(now()) {
+            sn.active[s.Door] = s
+        }
+    }
+    return sn, nil
+}
+
+// saveLocked writes the snoozes to a temporary file first, a crash mid-write
+// leaves the previous file in place.
+func (sn *snoozes) saveLocked() error {
+    b, err := json.Marshal(sn.listLocked(time.Time{}))
+    if err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(sn.path), ".snoozes-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(b); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), sn.path)
+}
+
+func (sn *snoozes) listLocked(at time.Time) []snooze {
+    list := []snooze{}
+    for _, s := range sn.active {
+        if s.Until.After(at) {
+            list = append(list, s)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Door < list[j].Door })
+    return list
+}
+
+// list returns the snoozes still active at t.
+func (sn *snoozes) list(t time.Time) []snooze {
+    sn.mu.Lock()
+    defer sn.mu.Unlock()
+    return sn.listLocked(t)
+}
+
+// snoozed reports whether alerts for the door are silenced at t.
+func (sn *snoozes) snoozed(door string, t time.Time) bool {
+    if sn == nil {
+        return false
+    }
+    sn.mu.Lock()
+    defer sn.mu.Unlock()
+    for _, key := range []string{door, ""} {
+        if s, ok := sn.active[key]; ok && s.Until.After(t) {
+            return true
+        }
+    }
+    return false
+}
+
+func (sn *snoozes) add(s snooze) error {
+    sn.mu.Lock()
+    defer sn.mu.Unlock()
+    sn.active[s.Door] = s
+    if err := sn.saveLocked(); err != nil {
+        return err
+    }
+    sendNotification(sn.notifiers, fmt.Sprintf("[%s] Alerts snoozed by %s until %s", s.target(), s.By, s.Until.Format(time.RFC1123)))
+    return nil
+}
+
+// remove ends the snooze of the door, or every snooze when door is empty.
+// It reports whether there was anything to remove.
+func (sn *snoozes) remove(door, by string) (bool, error) {
+    sn.mu.Lock()
+    defer sn.mu.Unlock()
+
+    s := snooze{Door: door}
+    if door == "" {
+        if len(sn.active) == 0 {
+            return false, nil
+        }
+        clear(sn.active)
+    } else {
+        if _, ok := sn.active[door]; !ok {
+            return false, nil
+        }
+        delete(sn.active, door)
+    }
+    if err := sn.saveLocked(); err != nil {
+        return true, err
+    }
+    sendNotification(sn.notifiers, fmt.Sprintf("[%s] Alerts resumed by %s", s.target(), by))
+    return true, nil
+}
+
+// snoozedBy names who changed a snooze, from the by query parameter or
+// else the client address.
+func snoozedBy(r *http.Request) string {
+    if by := r.URL.Query().Get("by"); by != "" {
+        return by
+    }
+    return r.RemoteAddr
+}
+
+// snoozeDoor returns the door query parameter, empty for every door.
+func snoozeDoor(w http.ResponseWriter, r *http.Request, doors []*door) (string, bool) {
+    name := r.URL.Query().Get("door")
+    if name != "" && findDoor(doors, name) == nil {
+        http.Error(w, fmt.Sprintf("unknown door %q", name), http.StatusNotFound)
+        return "", false
+    }
+    return name, true
+}
+
+func snoozeListHandler(sn *snoozes) http.HandlerFunc {
+    return func(w http.ResponseWriter, _ *http.Request) {
+        writeJSON(w, sn.list(now()))
+    }
+}
+
+// snoozeHandler silences alerts for ?duration=, for ?door= or every door.
+func snoozeHandler(sn *snoozes, doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        name, ok := snoozeDoor(w, r, doors)
+        if !ok {
+            return
+        }
+        d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+        if err != nil || d <= 0 || d > maxSnooze {
+            http.Error(w, fmt.Sprintf("invalid duration %q, must be positive and at most %s", r.URL.Query().Get("duration"), maxSnooze), http.StatusBadRequest)
+            return
+        }
+
+        s := snooze{Door: name, Until: now().Add(d), By: snoozedBy(r)}
+        if err := sn.add(s); err != nil {
+            log.Println("Error saving snooze:", err)
+            http.Error(w, "could not save snooze", http.StatusInternalServerError)
+            return
+        }
+        log.Printf("Alerts for %s snoozed by %s until %s", s.target(), s.By, s.Until.Format(time.RFC1123))
+        writeJSON(w, s)
+    }
+}
+
+// unsnoozeHandler ends the snooze of ?door=, or every snooze.
+func unsnoozeHandler(sn *snoozes, doors []*door) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        name, ok := snoozeDoor(w, r, doors)
+        if !ok {
+            return
+        }
+        removed, err := sn.remove(name, snoozedBy(r))
+        if err != nil {
+            log.Println("Error saving snoozes:", err)
+            http.Error(w, "could not save snoozes", http.StatusInternalServerError)
+            return
+        }
+        if !removed {
+            http.Error(w, "no active snooze", http.StatusNotFound)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+func TestSnoozeHandlers__SilenceAlertsAcrossRestarts(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "snoozes.json")
+	n := make(chanNotifier, 10)
+	sn, err := openSnoozes(path, []Notifier{n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, doors := newTestDoors(t, doorConfig{Name: "bay1", PinNumber: 12}, doorConfig{Name: "bay2", PinNumber: 16})
+	ts := httptest.NewServer(newMux(doors, nil, newEventHub(), sn, nil, nil, nil))
+	defer ts.Close()
+
+	do := func(method, query string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+"/alerts/snooze"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for query, want := range map[string]int{
+		"?duration=soon":               http.StatusBadRequest,
+		"?duration=-1h":                http.StatusBadRequest,
+		"?duration=2h&door=nope":       http.StatusNotFound,
+		"?duration=2h&door=bay1&by=jo": http.StatusOK,
+	} {
+		if got := do(http.MethodPost, query); got != want {
+			t.Errorf("POST %s: got %d, want %d", query, got, want)
+		}
+	}
+	if m := n.next(t); !strings.HasPrefix(m, "[bay1] Alerts snoozed by jo until ") {
+		t.Errorf("want snooze announced, got %q", m)
+	}
+
+	// a restart picks the snooze back up
+	sn, err = openSnoozes(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := clock.Now().Add(time.Hour)
+	if !sn.snoozed("bay1", at) || sn.snoozed("bay2", at) {
+		t.Errorf("want only bay1 snoozed after reopening, got %+v", sn.list(at))
+	}
+	if sn.snoozed("bay1", at.Add(2*time.Hour)) {
+		t.Error("want snooze expired after 2h")
+	}
+
+	if got := do(http.MethodDelete, "?door=bay1&by=jo"); got != http.StatusNoContent {
+		t.Errorf("DELETE: got %d, want 204", got)
+	}
+	if m := n.next(t); m != "[bay1] Alerts resumed by jo" {
+		t.Errorf("want resume announced, got %q", m)
+	}
+	if got := do(http.MethodDelete, ""); got != http.StatusNotFound {
+		t.Errorf("DELETE without snoozes: got %d, want 404", got)
+	}
+}
+
+func TestAlerter__HoldsAlertsWhileSnoozed(t *testing.T) {
+	t.Parallel()
+	n := make(chanNotifier, 10)
+	cfg := alertConfig{}
+	cfg.setDefaults()
+	a := newAlerter("garage", cfg, []Notifier{n})
+
+	start := time.Date(2024, 6, 4, 23, 0, 0, 0, time.UTC)
+	a.snoozes = &snoozes{active: map[string]snooze{"": {Until: start.Add(time.Hour)}}}
+
+	a.update(sensor.Open, true, start)
+	a.update(sensor.Open, true, start.Add(30*time.Minute))
+	n.none(t)
+
+	a.update(sensor.Open, true, start.Add(time.Hour))
+	if m := n.next(t); !strings.HasPrefix(m, "[garage] Door open at night, open for 1h") {
+		t.Errorf("want night alert once the snooze ends, got %q", m)
+	}
+}
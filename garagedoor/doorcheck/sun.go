@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+    sunrise = "sunrise"
+    sunset = "sunset"
+
+    // Julian date of the Unix epoch and of the J2000 epoch
+    julianUnixEpoch = 2440587.5
+    julianJ2000 = 2451545.0
+)
+
+// sunTimes computes sunrise and sunset on the calendar day of date, in
+// date's location, with the NOAA sunrise equation. lat and lon are in
+// degrees, east and north positive. The result is accurate to a minute or
+// two, plenty for deciding when night starts. Inside the polar circles the
+// sun may not rise or set at all, sunrise and sunset are then both solar
+// noon (polar night) or 12 hours either side of it (midnight sun).
+func sunTimes(date time.Time, lat, lon float64) (time.Time, time.Time) {
+    rad := math.Pi / 180
+    y, m, d := date.Date()
+    noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+    n := math.Round(float64(noon.Unix())/86400 + julianUnixEpoch - julianJ2000)
+
+    // mean solar time, solar mean anomaly, equation of the center and
+    // ecliptic longitude
+    jStar := n - lon/360
+    meanAnomaly := math.Mod(357.5291+0.98560028*jStar, 360)
+    center := 1.9148*math.Sin(meanAnomaly*rad) + 0.02*math.Sin(2*meanAnomaly*rad) + 0.0003*math.Sin(3*meanAnomaly*rad)
+    eclipticLon := math.Mod(meanAnomaly+center+180+102.9372, 360)
+
+    transit := julianJ2000 + jStar + 0.0053*math.Sin(meanAnomaly*rad) - 0.0069*math.Sin(2*eclipticLon*rad)
+    declination := math.Asin(math.Sin(eclipticLon*rad) * math.Sin(23.4397*rad))
+
+    // -0.833 degrees accounts for refraction and the size of the sun's disc
+    cosHourAngle := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*math.Sin(declination)) /
+        (math.Cos(lat*rad) * math.Cos(declination))
+    hourAngle := math.Acos(max(-1, min(1, cosHourAngle))) / rad
+
+    return julianToTime(transit-hourAngle/360, date.Location()), julianToTime(transit+hourAngle/360, date.Location())
+}
+
+func julianToTime(j float64, loc *time.Location) time.Time {
+    secs := (j - julianUnixEpoch) * 86400
+    return time.Unix(int64(math.Round(secs)), 0).In(loc)
+}
+
+// sunClock caches sunrise and sunset at a location by day. A schedule asks
+// for the days either side of the one it checks, so a few are kept.
+type sunClock struct {
+    lat, lon float64
+
+    mu sync.Mutex
+    days map[string]sunDay
+}
+
+type sunDay struct {
+    rise, set time.Time
+}
+
+// days further than this from the one asked for are dropped from the cache
+const sunCacheDays = 3
+
+func newSunClock(lat, lon float64) *sunClock {
+    return &sunClock{lat: lat, lon: lon, days: make(map[string]sunDay)}
+}
+
+// times returns sunrise and sunset on the day of t.
+func (sc *sunClock) times(t time.Time) (time.Time, time.Time) {
+    sc.mu.Lock()
+    defer sc.mu.Unlock()
+
+    day := t.Format(time.DateOnly)
+    if d, ok := sc.days[day]; ok {
+        return d.rise, d.set
+    }
+    for k, d := range sc.days {
+        if t.Sub(d.rise).Abs() > sunCacheDays*24*time.Hour {
+            delete(sc.days, k)
+        }
+    }
+    rise, set := sunTimes(t, sc.lat, sc.lon)
+    sc.days[day] = sunDay{rise, set}
+    log.Printf("Sunrise at %s, sunset at %s on %s", rise.Format(time.Kitchen), set.Format(time.Kitchen), day)
+    return rise, set
+}
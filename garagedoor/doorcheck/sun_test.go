@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSunTimes(t *testing.T) {
+	t.Parallel()
+	mdt := time.FixedZone("MDT", -6*3600)
+	tests := []struct {
+		name      string
+		date      time.Time
+		lat, lon  float64
+		rise, set string
+	}{
+		{"calgary summer solstice", time.Date(2024, 6, 21, 0, 0, 0, 0, mdt), 51.05, -114.07, "5:20AM", "9:56PM"},
+		{"london winter solstice", time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 51.51, -0.13, "8:04AM", "3:54PM"},
+		{"quito equinox", time.Date(2024, 3, 20, 0, 0, 0, 0, time.FixedZone("ECT", -5*3600)), -0.18, -78.47, "6:17AM", "6:24PM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rise, set := sunTimes(tt.date, tt.lat, tt.lon)
+			for _, c := range []struct {
+				what string
+				got  time.Time
+				want string
+			}{{"sunrise", rise, tt.rise}, {"sunset", set, tt.set}} {
+				want, _ := time.Parse(time.Kitchen, c.want)
+				want = time.Date(tt.date.Year(), tt.date.Month(), tt.date.Day(), want.Hour(), want.Minute(), 0, 0, tt.date.Location())
+				if d := c.got.Sub(want).Abs(); d > 3*time.Minute {
+					t.Errorf("%s = %s, want %s", c.what, c.got.Format(time.Kitchen), c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSunTimes__PolarNight(t *testing.T) {
+	t.Parallel()
+	rise, set := sunTimes(time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 69.65, 18.96)
+	if !rise.Equal(set) {
+		t.Errorf("want no daylight in Tromsø in December, got %s to %s", rise.Format(time.Kitchen), set.Format(time.Kitchen))
+	}
+}
+
+func TestYamlHour__ParsesFixedAndSunRelativeTimes(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"9:00pm", "9:00pm", false},
+		{"sunset", "sunset", false},
+		{"sunset+30m", "sunset+30m0s", false},
+		{"sunrise - 15m", "sunrise-15m0s", false},
+		{"sunset30m", "", true},
+		{"sunrise+soon", "", true},
+		{"21:00", "", true},
+	}
+	for _, tt := range tests {
+		var yh yamlHour
+		err := yaml.Unmarshal([]byte(`"`+tt.in+`"`), &yh)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsing %q: got error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && yh.String() != tt.want {
+			t.Errorf("parsing %q: got %s, want %s", tt.in, yh, tt.want)
+		}
+	}
+}
+
+func TestYamlHour__OnFollowsTheSun(t *testing.T) {
+	t.Parallel()
+	sun := newSunClock(51.05, -114.07)
+	mdt := time.FixedZone("MDT", -6*3600)
+	yh := yamlHour{event: sunset, offset: 30 * time.Minute}
+
+	summer := yh.on(time.Date(2024, 6, 21, 12, 0, 0, 0, mdt), sun)
+	autumn := yh.on(time.Date(2024, 9, 21, 12, 0, 0, 0, mdt), sun)
+	if summer.Hour() != 22 || autumn.Hour() != 20 {
+		t.Errorf("want night to start after 10pm in June and 8pm in September, got %s and %s",
+			summer.Format(time.Kitchen), autumn.Format(time.Kitchen))
+	}
+}
+
+func TestSunClock__ComputesEachDayOnce(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	sun := newSunClock(51.05, -114.07)
+	window := nightWindow{yamlHour{event: sunset}, yamlHour{event: sunrise}}
+	s, err := newSchedule(window, nil, sun, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each check asks for the day before and after too
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.FixedZone("MDT", -6*3600))
+	for at := start; at.Before(start.AddDate(0, 0, 2)); at = at.Add(time.Hour) {
+		s.at(at)
+	}
+	if n := strings.Count(logs.String(), "Sunrise at"); n != 3 {
+		t.Errorf("computed sunrise %d times over 2 days, want once for each of the 3 days checked:\n%s", n, logs.String())
+	}
+
+	// far off days do not pile up in the cache
+	s.at(start.AddDate(0, 1, 0))
+	if n := len(sun.days); n > 3 {
+		t.Errorf("%d days cached, want at most the 3 around the last check", n)
+	}
+}
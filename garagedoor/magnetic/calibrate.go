@@ -0,0 +1,99 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "doorcheck/sensor"
+)
+
+const (
+    // level changes closer together than this belong to the same press
+    burstGap = 100 * time.Millisecond
+    minDebounce = 20 * time.Millisecond
+)
+
+// edge is a level change seen at offset at from the start of sampling.
+type edge struct {
+    at time.Duration
+    state sensor.State
+}
+
+// sample reads the sensor every interval for duration, returning the
+// first reading, the number of samples taken and every level change seen.
+func sample(read func() sensor.State, duration, interval time.Duration) (sensor.State, int, []edge) {
+    var edges []edge
+    start := time.Now()
+    first := read()
+    last := first
+    samples := 1
+    for elapsed := time.Duration(0); elapsed < duration; elapsed = time.Since(start) {
+        time.Sleep(interval)
+        cur := read()
+        samples++
+        if cur != last {
+            edges = append(edges, edge{at: time.Since(start), state: cur})
+            last = cur
+        }
+    }
+    return first, samples, edges
+}
+
+type calibration struct {
+    Pin int `json:"pin"`
+    Duration string `json:"duration"`
+    Samples int `json:"samples"`
+    // raw level changes, bounces included
+    Transitions int `json:"transitions"`
+    // door state changes once the contact settled
+    Changes int `json:"changes"`
+    Bounces int `json:"bounces"`
+    LongestBounce string `json:"longest_bounce"`
+    RecommendedDebounce string `json:"recommended_debounce,omitempty"`
+}
+
+// analyze groups edges into bursts, each one a door movement with its
+// contact bounce, and recommends a debounce twice the longest burst.
+func analyze(start sensor.State, edges []edge) calibration {
+    c := calibration{Transitions: len(edges)}
+
+    var longest time.Duration
+    before := start
+    for i := 0; i < len(edges); {
+        j := i
+        for j+1 < len(edges) && edges[j+1].at-edges[j].at < burstGap {
+            j++
+        }
+        longest = max(longest, edges[j].at-edges[i].at)
+        if edges[j].state != before {
+            c.Changes++
+        }
+        before = edges[j].state
+        i = j + 1
+    }
+
+    c.Bounces = c.Transitions - c.Changes
+    c.LongestBounce = longest.String()
+    if c.Transitions > 0 {
+        // rounded up to the next 10ms
+        step := 10 * time.Millisecond
+        debounce := max(2*longest, minDebounce)
+        debounce = (debounce + step - 1) / step * step
+        c.RecommendedDebounce = debounce.String()
+    }
+    return c
+}
+
+func (c calibration) String() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Sampled pin %d %d times over %s\n", c.Pin, c.Samples, c.Duration)
+    fmt.Fprintf(&b, "Transitions: %d (%d door changes, %d bounces)\n", c.Transitions, c.Changes, c.Bounces)
+    fmt.Fprintf(&b, "Longest bounce: %s\n", c.LongestBounce)
+    if c.RecommendedDebounce == "" {
+        b.WriteString("No transitions seen, move the door while calibrating")
+    } else {
+        fmt.Fprintf(&b, "Recommended doorcheck debounce: %s", c.RecommendedDebounce)
+    }
+    return b.String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"doorcheck/sensor"
+)
+
+func TestAnalyze__SeparatesBouncesFromDoorChanges(t *testing.T) {
+	t.Parallel()
+	ms := time.Millisecond
+	edges := []edge{
+		// door opens, contact bounces for 12ms
+		{1000 * ms, sensor.Open},
+		{1004 * ms, sensor.Closed},
+		{1012 * ms, sensor.Open},
+		// door closes cleanly
+		{5000 * ms, sensor.Closed},
+		// glitch that settles back to closed
+		{8000 * ms, sensor.Open},
+		{8003 * ms, sensor.Closed},
+	}
+
+	got := analyze(sensor.Closed, edges)
+	want := calibration{
+		Transitions:         6,
+		Changes:             2,
+		Bounces:             4,
+		LongestBounce:       "12ms",
+		RecommendedDebounce: "30ms",
+	}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestAnalyze__NoRecommendationWithoutTransitions(t *testing.T) {
+	t.Parallel()
+	if got := analyze(sensor.Closed, nil); got.RecommendedDebounce != "" {
+		t.Errorf("want no recommendation, got %q", got.RecommendedDebounce)
+	}
+}
@@ -1,5 +1,15 @@
 module magnetic
 
-go 1.23.2
+go 1.23.4
 
-require github.com/stianeikeland/go-rpio/v4 v4.6.0
+require (
+	doorcheck v0.0.0
+	github.com/stianeikeland/go-rpio/v4 v4.6.0
+)
+
+// the sensor types are shared with doorcheck, which needs the API
+// authentication of the hue services
+replace (
+	doorcheck => ../doorcheck
+	github.com/ezebunandu/gohome/httpauth => ../../httpauth
+)
@@ -1,35 +1,218 @@
 package main
 
 import (
+    "context"
+    "encoding/json"
+    "flag"
     "fmt"
     "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "doorcheck/gpio"
+    "doorcheck/sensor"
 
     "github.com/stianeikeland/go-rpio/v4"
 )
 
-const pinNumber = 12
+const usage = `Usage: magnetic <command> [flags]
 
-type state rpio.State
+Diagnostic tool for the garage door reed switch.
 
-func (s state) String() string {
-    if s == state(rpio.Low) {
-        return "Open"
-    }
+Commands:
+  read       print the current door state
+  watch      print timestamped transitions until interrupted
+  calibrate  sample the sensor and recommend a debounce for doorcheck
 
-    return "Closed"
+Run 'magnetic <command> -h' for the flags of a command.`
+
+// sensorFlags are the flags shared by every command.
+type sensorFlags struct {
+    pin int
+    pull string
+    openWhen string
+    json bool
 }
 
-func main() {
-    if err := rpio.Open(); err != nil {
-        fmt.Println(err)
-        os.Exit(1)
+func (sf *sensorFlags) register(fs *flag.FlagSet) {
+    fs.IntVar(&sf.pin, "pin", 12, "GPIO pin of the reed switch")
+    fs.StringVar(&sf.pull, "pull", "up", "Pull mode: up, down or off")
+    fs.StringVar(&sf.openWhen, "open-when", "low", "Pin level while the door is open: low or high")
+    fs.BoolVar(&sf.json, "json", false, "Print JSON instead of text")
+}
+
+// doorSensor is the configured reed switch pin.
+type doorSensor struct {
+    pin gpio.Pin
+    number int
+    openWhen rpio.State
+}
+
+func (ds doorSensor) read() sensor.State {
+    return sensor.Read(ds.pin, ds.openWhen)
+}
+
+func (sf *sensorFlags) open() (gpio.Backend, doorSensor, error) {
+    pull, err := sensor.ParsePull(sf.pull)
+    if err != nil {
+        return nil, doorSensor{}, err
+    }
+    openWhen, err := gpio.ParseLevel(sf.openWhen)
+    if err != nil {
+        return nil, doorSensor{}, err
     }
-    defer rpio.Close()
 
-    pin := rpio.Pin(pinNumber)
+    backend, err := gpio.OpenRPIO()
+    if err != nil {
+        return nil, doorSensor{}, err
+    }
+    pin := backend.Pin(sf.pin)
     pin.Input()
-    rpio.PullMode(pin, rpio.PullUp)
-    door := state(pin.Read())
+    pin.Pull(pull)
+    return backend, doorSensor{pin: pin, number: sf.pin, openWhen: openWhen}, nil
+}
+
+type reading struct {
+    Time time.Time `json:"time"`
+    Pin int `json:"pin"`
+    DoorState sensor.State `json:"door_state"`
+    DoorStateText string `json:"door_state_text"`
+}
 
-    fmt.Println("Door is: ", door)
-}
\ No newline at end of file
+func newReading(pin int, s sensor.State) reading {
+    return reading{
+        Time: time.Now(),
+        Pin: pin,
+        DoorState: s,
+        DoorStateText: fmt.Sprint(s),
+    }
+}
+
+func printResult(asJSON bool, v any, text string) error {
+    if asJSON {
+        return json.NewEncoder(os.Stdout).Encode(v)
+    }
+    _, err := fmt.Println(text)
+    return err
+}
+
+func runRead(args []string) error {
+    fs := flag.NewFlagSet("read", flag.ExitOnError)
+    var sf sensorFlags
+    sf.register(fs)
+    fs.Parse(args)
+
+    backend, ds, err := sf.open()
+    if err != nil {
+        return err
+    }
+    defer backend.Close()
+
+    r := newReading(ds.number, ds.read())
+    return printResult(sf.json, r, fmt.Sprint("Door is: ", r.DoorState))
+}
+
+func runWatch(args []string) error {
+    fs := flag.NewFlagSet("watch", flag.ExitOnError)
+    var sf sensorFlags
+    sf.register(fs)
+    interval := fs.Duration("interval", time.Millisecond, "How often the pin is sampled")
+    debounce := fs.Duration("debounce", 0, "Only report states held this long, 0 shows every bounce")
+    fs.Parse(args)
+
+    backend, ds, err := sf.open()
+    if err != nil {
+        return err
+    }
+    defer backend.Close()
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    last := ds.read()
+    r := newReading(ds.number, last)
+    if err := printResult(sf.json, r, fmt.Sprintf("%s Door is: %s", r.Time.Format(time.StampMilli), last)); err != nil {
+        return err
+    }
+
+    ticker := time.NewTicker(*interval)
+    defer ticker.Stop()
+    // when the pin first read differently from last
+    var since time.Time
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-ticker.C:
+        }
+
+        cur := ds.read()
+        if cur == last {
+            since = time.Time{}
+            continue
+        }
+        if since.IsZero() {
+            since = time.Now()
+        }
+        if time.Since(since) < *debounce {
+            continue
+        }
+
+        last, since = cur, time.Time{}
+        r := newReading(ds.number, cur)
+        if err := printResult(sf.json, r, fmt.Sprintf("%s Door is: %s", r.Time.Format(time.StampMilli), cur)); err != nil {
+            return err
+        }
+    }
+}
+
+func runCalibrate(args []string) error {
+    fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+    var sf sensorFlags
+    sf.register(fs)
+    duration := fs.Duration("duration", 10*time.Second, "How long to sample for")
+    interval := fs.Duration("interval", time.Millisecond, "How often the pin is sampled")
+    fs.Parse(args)
+
+    backend, ds, err := sf.open()
+    if err != nil {
+        return err
+    }
+    defer backend.Close()
+
+    fmt.Fprintf(os.Stderr, "Sampling pin %d for %s, open and close the door a few times...\n", ds.number, *duration)
+    start, samples, edges := sample(ds.read, *duration, *interval)
+    c := analyze(start, edges)
+    c.Pin = ds.number
+    c.Duration = duration.String()
+    c.Samples = samples
+
+    return printResult(sf.json, c, c.String())
+}
+
+func main() {
+    if len(os.Args) < 2 {
+        fmt.Fprintln(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    var err error
+    switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+    case "read":
+        err = runRead(args)
+    case "watch":
+        err = runWatch(args)
+    case "calibrate":
+        err = runCalibrate(args)
+    case "-h", "-help", "--help", "help":
+        fmt.Println(usage)
+    default:
+        fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", cmd, usage)
+        os.Exit(2)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
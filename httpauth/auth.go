@@ -0,0 +1,352 @@
+// Package httpauth authenticates the requests to the home automation APIs,
+// with keys loaded from a secrets file. A request either carries the key
+// secret as a bearer token:
+//
+//	Authorization: Bearer <secret>
+//
+// or is signed with it, so the secret never goes over the wire:
+//
+//	Authorization: HMAC-SHA256 <key name>:<signature>
+//	X-Auth-Timestamp: <unix seconds>
+//
+// the signature being the hex HMAC-SHA256, keyed with the secret, of
+//
+//	METHOD\nREQUEST_URI\nTIMESTAMP\nhex(SHA256(body))
+//
+// A signature is only accepted once. Every key has scopes, the routes
+// requiring one of them. Failed attempts are written to an audit log.
+package httpauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// looking at the state of things
+	ScopeRead = "read"
+	// changing the state of things, e.g. turning lights on
+	ScopeControl = "control"
+
+	// how far the timestamp of a signed request may be from now
+	MaxSkew = 5 * time.Minute
+	// largest body a signed request may have
+	maxSignedBody = 1 << 20
+	// most signatures remembered, for the requests of the last MaxSkew
+	maxSeenSignatures = 10000
+
+	schemeBearer = "Bearer"
+	schemeHMAC   = "HMAC-SHA256"
+	headerTime   = "X-Auth-Timestamp"
+)
+
+// Config is how a service sets up authentication, from its config file.
+type Config struct {
+	// the keys, required unless Disabled
+	SecretsFile string `yaml:"secrets_file"`
+	// serve every request without authentication
+	Disabled bool `yaml:"disabled"`
+	// where failed attempts are written, stderr when empty
+	AuditLog string `yaml:"audit_log"`
+}
+
+// Key is a secret shared with a client.
+type Key struct {
+	// who the key is for, reported in the audit log
+	Name   string   `yaml:"name"`
+	Secret string   `yaml:"secret"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// Authenticator checks requests against the keys.
+type Authenticator struct {
+	keys []Key
+	now  func() time.Time
+
+	mu    sync.Mutex
+	audit io.Writer
+
+	seenMu sync.Mutex
+	// signatures accepted, until their timestamp is too old to replay
+	seen    map[string]time.Time
+	maxSeen int
+}
+
+// New returns an authenticator for the keys, auditing to stderr.
+func New(keys []Key) (*Authenticator, error) {
+	names := make(map[string]bool)
+	for i, k := range keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("key %d has no name", i+1)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("key %q is defined twice", k.Name)
+		}
+		names[k.Name] = true
+		// short secrets can be guessed, or the HMAC brute forced
+		if len(k.Secret) < 16 {
+			return nil, fmt.Errorf("key %q: secret must be at least 16 characters", k.Name)
+		}
+		if len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("key %q has no scopes", k.Name)
+		}
+		for _, s := range k.Scopes {
+			if s != ScopeRead && s != ScopeControl {
+				return nil, fmt.Errorf("key %q: invalid scope %q, must be %s or %s", k.Name, s, ScopeRead, ScopeControl)
+			}
+		}
+	}
+	return &Authenticator{
+		keys:    keys,
+		now:     time.Now,
+		audit:   os.Stderr,
+		seen:    make(map[string]time.Time),
+		maxSeen: maxSeenSignatures,
+	}, nil
+}
+
+// Load reads the keys of a secrets file:
+//
+//	keys:
+//	  - name: home-assistant
+//	    secret: "..."
+//	    scopes: [read, control]
+func Load(path string) (*Authenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var secrets struct {
+		Keys []Key `yaml:"keys"`
+	}
+	if err := yaml.NewDecoder(f).Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("parsing secrets file %s: %w", path, err)
+	}
+	if len(secrets.Keys) == 0 {
+		return nil, fmt.Errorf("secrets file %s has no keys", path)
+	}
+	return New(secrets.Keys)
+}
+
+// Open sets up authentication as configured. It returns a nil
+// Authenticator, letting every request through, only when authentication
+// is disabled, and fails without a secrets file otherwise.
+func Open(cfg Config) (*Authenticator, error) {
+	if cfg.Disabled {
+		if cfg.SecretsFile != "" {
+			return nil, errors.New("auth is disabled but has a secrets_file")
+		}
+		log.Println("WARNING: auth is disabled, API requests are not authenticated")
+		return nil, nil
+	}
+	if cfg.SecretsFile == "" {
+		return nil, errors.New("no auth secrets_file configured, set auth.disabled to serve without authentication")
+	}
+	a, err := Load(cfg.SecretsFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AuditLog != "" {
+		f, err := os.OpenFile(cfg.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		a.SetAuditLog(f)
+	}
+	return a, nil
+}
+
+// SetAuditLog sets where failed attempts are written, as JSON lines.
+func (a *Authenticator) SetAuditLog(w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.audit = w
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	// the key claimed or used, when known
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (a *Authenticator) fail(r *http.Request, key, reason string) {
+	b, err := json.Marshal(auditEntry{
+		Time:   a.now(),
+		Remote: r.RemoteAddr,
+		Method: r.Method,
+		Path:   r.URL.RequestURI(),
+		Key:    key,
+		Reason: reason,
+	})
+	if err != nil {
+		log.Println("ERROR:", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.audit.Write(append(b, '\n')); err != nil {
+		log.Println("ERROR: writing audit log:", err)
+	}
+}
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// authenticate returns the key a request is from.
+func (a *Authenticator) authenticate(r *http.Request) (*Key, string, error) {
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	switch scheme {
+	case "":
+		return nil, "", fmt.Errorf("%w: no credentials", errUnauthenticated)
+	case schemeBearer:
+		for i := range a.keys {
+			if subtle.ConstantTimeCompare([]byte(a.keys[i].Secret), []byte(credentials)) == 1 {
+				return &a.keys[i], a.keys[i].Name, nil
+			}
+		}
+		return nil, "", fmt.Errorf("%w: invalid token", errUnauthenticated)
+	case schemeHMAC:
+		return a.verify(r, credentials)
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported scheme %q", errUnauthenticated, scheme)
+	}
+}
+
+func (a *Authenticator) verify(r *http.Request, credentials string) (*Key, string, error) {
+	name, sig, _ := strings.Cut(credentials, ":")
+	i := slices.IndexFunc(a.keys, func(k Key) bool { return k.Name == name })
+	if i < 0 {
+		return nil, name, fmt.Errorf("%w: unknown key", errUnauthenticated)
+	}
+	key := &a.keys[i]
+
+	ts := r.Header.Get(headerTime)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, name, fmt.Errorf("%w: invalid %s", errUnauthenticated, headerTime)
+	}
+	if skew := a.now().Sub(time.Unix(sec, 0)); skew > MaxSkew || skew < -MaxSkew {
+		return nil, name, fmt.Errorf("%w: timestamp too far from now", errUnauthenticated)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return nil, name, fmt.Errorf("%w: reading body: %v", errUnauthenticated, err)
+	}
+	if len(body) > maxSignedBody {
+		return nil, name, fmt.Errorf("%w: body too large", errUnauthenticated)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := Sign(key.Secret, r.Method, r.URL.RequestURI(), ts, body)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return nil, name, fmt.Errorf("%w: invalid signature", errUnauthenticated)
+	}
+	if err := a.remember(want, time.Unix(sec, 0).Add(MaxSkew)); err != nil {
+		return nil, name, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	return key, name, nil
+}
+
+// remember records a signature until it expires, failing when it was
+// seen before, so a captured request cannot be replayed. The signatures no
+// longer valid are dropped once there are too many, and new ones refused
+// while there still are.
+func (a *Authenticator) remember(sig string, expires time.Time) error {
+	a.seenMu.Lock()
+	defer a.seenMu.Unlock()
+
+	now := a.now()
+	if exp, ok := a.seen[sig]; ok && now.Before(exp) {
+		return errors.New("replayed signature")
+	}
+	if len(a.seen) >= a.maxSeen {
+		for s, exp := range a.seen {
+			if !now.Before(exp) {
+				delete(a.seen, s)
+			}
+		}
+		if len(a.seen) >= a.maxSeen {
+			return errors.New("too many signed requests")
+		}
+	}
+	a.seen[sig] = expires
+	return nil
+}
+
+// Sign returns the signature of a request, for clients signing theirs.
+func Sign(secret, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, requestURI, timestamp, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the headers signing a request with a key. The body has
+// to be given again, the request's having been read from or not.
+func SignRequest(r *http.Request, name, secret string, body []byte, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(headerTime, ts)
+	r.Header.Set("Authorization", schemeHMAC+" "+name+":"+Sign(secret, r.Method, r.URL.RequestURI(), ts, body))
+}
+
+type contextKey struct{}
+
+// KeyName returns the name of the key a request was authenticated with.
+func KeyName(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// Require wraps a handler to only serve the requests authenticated with a
+// key having scope. Others get a 401, or a 403 when the key lacks the
+// scope. A nil Authenticator lets every request through.
+func (a *Authenticator) Require(scope string, h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, name, err := a.authenticate(r)
+		if err != nil {
+			a.fail(r, name, err.Error())
+			w.Header().Set("WWW-Authenticate", schemeBearer)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(key.Scopes, scope) {
+			a.fail(r, name, "missing scope "+scope)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key.Name)))
+	})
+}
+
+// RequireFunc is Require for a handler function.
+func (a *Authenticator) RequireFunc(scope string, h http.HandlerFunc) http.Handler {
+	return a.Require(scope, h)
+}
@@ -0,0 +1,225 @@
+package httpauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	controlSecret = "0123456789abcdef-control"
+	readSecret    = "0123456789abcdef-read"
+)
+
+func newTestAuth(t *testing.T) (*Authenticator, *bytes.Buffer) {
+	t.Helper()
+	a, err := New([]Key{
+		{Name: "home-assistant", Secret: controlSecret, Scopes: []string{ScopeRead, ScopeControl}},
+		{Name: "dashboard", Secret: readSecret, Scopes: []string{ScopeRead}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &bytes.Buffer{}
+	a.SetAuditLog(audit)
+	return a, audit
+}
+
+// echo answers with the key name and the body it read.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Write([]byte(KeyName(r.Context()) + " " + string(body)))
+})
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRequire__Bearer(t *testing.T) {
+	t.Parallel()
+	a, audit := newTestAuth(t)
+	h := a.Require(ScopeControl, echo)
+
+	tests := []struct {
+		name   string
+		header string
+		code   int
+		reason string
+	}{
+		{"control key", "Bearer " + controlSecret, http.StatusOK, ""},
+		{"no credentials", "", http.StatusUnauthorized, "unauthenticated: no credentials"},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized, "unauthenticated: invalid token"},
+		{"basic auth", "Basic Zm9vOmJhcg==", http.StatusUnauthorized, `unauthenticated: unsupported scheme "Basic"`},
+		{"read key", "Bearer " + readSecret, http.StatusForbidden, "missing scope control"},
+	}
+	for _, tt := range tests {
+		audit.Reset()
+		r := httptest.NewRequest("POST", "/turnOn", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := serve(h, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+		if tt.reason == "" {
+			if audit.Len() > 0 {
+				t.Errorf("%s: audited %s", tt.name, audit)
+			}
+			if body := w.Body.String(); body != "home-assistant " {
+				t.Errorf("%s: handler saw key %q", tt.name, body)
+			}
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal(audit.Bytes(), &e); err != nil {
+			t.Fatalf("%s: audit log %q: %v", tt.name, audit, err)
+		}
+		if e.Reason != tt.reason || e.Method != "POST" || e.Path != "/turnOn" {
+			t.Errorf("%s: audited %+v, want reason %q", tt.name, e, tt.reason)
+		}
+	}
+}
+
+func TestRequire__HMAC(t *testing.T) {
+	t.Parallel()
+	a, audit := newTestAuth(t)
+	now := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	h := a.Require(ScopeControl, echo)
+
+	signed := func(body string, at time.Time) *http.Request {
+		r := httptest.NewRequest("POST", "/colorloop/lamps?duration=15m", strings.NewReader(body))
+		SignRequest(r, "home-assistant", controlSecret, []byte(body), at)
+		return r
+	}
+
+	w := serve(h, signed(`{"a":1}`, now.Add(-time.Minute)))
+	if w.Code != http.StatusOK || w.Body.String() != `home-assistant {"a":1}` {
+		t.Errorf("signed request: got %d %q", w.Code, w.Body)
+	}
+
+	tampered := signed(`{"a":1}`, now)
+	tampered.Body = io.NopCloser(strings.NewReader(`{"a":2}`))
+	stale := signed("", now.Add(-MaxSkew-time.Second))
+	wrongKey := signed("", now)
+	SignRequest(wrongKey, "home-assistant", readSecret, nil, now)
+	unknown := signed("", now)
+	SignRequest(unknown, "intruder", controlSecret, nil, now)
+
+	for name, r := range map[string]*http.Request{
+		"tampered body": tampered,
+		"stale":         stale,
+		"wrong secret":  wrongKey,
+		"unknown key":   unknown,
+	} {
+		audit.Reset()
+		if w := serve(h, r); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", name, w.Code)
+		}
+		if !strings.Contains(audit.String(), `"key":`) {
+			t.Errorf("%s: audit entry has no key: %s", name, audit)
+		}
+	}
+}
+
+func TestRequire__RejectsReplayedSignatures(t *testing.T) {
+	t.Parallel()
+	a, audit := newTestAuth(t)
+	now := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	a.maxSeen = 2
+	h := a.Require(ScopeControl, echo)
+
+	signed := func(path string, at time.Time) *http.Request {
+		r := httptest.NewRequest("POST", path, nil)
+		SignRequest(r, "home-assistant", controlSecret, nil, at)
+		return r
+	}
+	first := signed("/turnOn", now)
+	replay := first.Clone(first.Context())
+
+	if w := serve(h, first); w.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", w.Code)
+	}
+	if w := serve(h, replay); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request: got %d, want 401", w.Code)
+	}
+	if !strings.Contains(audit.String(), "replayed signature") {
+		t.Errorf("replay not audited: %s", audit)
+	}
+
+	// the cache is full while the signatures could still be replayed
+	if w := serve(h, signed("/turnOff", now)); w.Code != http.StatusOK {
+		t.Errorf("second request: got %d", w.Code)
+	}
+	if w := serve(h, signed("/turnOn?for=1h", now)); w.Code != http.StatusUnauthorized {
+		t.Errorf("request over the cache size: got %d, want 401", w.Code)
+	}
+	now = now.Add(MaxSkew + time.Second)
+	if w := serve(h, signed("/turnOn", now)); w.Code != http.StatusOK {
+		t.Errorf("request once the old signatures expired: got %d", w.Code)
+	}
+}
+
+func TestOpen__RequiresSecretsUnlessDisabled(t *testing.T) {
+	t.Parallel()
+	if _, err := Open(Config{}); err == nil {
+		t.Error("no secrets file: want an error")
+	}
+	if _, err := Open(Config{Disabled: true, SecretsFile: "keys.yml"}); err == nil {
+		t.Error("disabled with a secrets file: want an error")
+	}
+	if a, err := Open(Config{Disabled: true}); err != nil || a != nil {
+		t.Errorf("disabled: got %v, %v, want no authenticator", a, err)
+	}
+}
+
+func TestRequire__NilLetsEverythingThrough(t *testing.T) {
+	t.Parallel()
+	var a *Authenticator
+	if w := serve(a.RequireFunc(ScopeControl, echo), httptest.NewRequest("POST", "/", nil)); w.Code != http.StatusOK {
+		t.Errorf("got %d, want 200", w.Code)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "secrets.yml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	a, err := Load(write("keys:\n  - name: ha\n    secret: " + controlSecret + "\n    scopes: [control]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.keys) != 1 || a.keys[0].Name != "ha" {
+		t.Errorf("keys = %+v", a.keys)
+	}
+
+	for name, content := range map[string]string{
+		"no keys":       "keys: []",
+		"short secret":  "keys: [{name: ha, secret: short, scopes: [read]}]",
+		"no scopes":     "keys: [{name: ha, secret: " + controlSecret + "}]",
+		"unknown scope": "keys: [{name: ha, secret: " + controlSecret + ", scopes: [admin]}]",
+		"duplicate": "keys: [{name: ha, secret: " + controlSecret + ", scopes: [read]}, " +
+			"{name: ha, secret: " + readSecret + ", scopes: [read]}]",
+	} {
+		if _, err := Load(write(content)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
@@ -1,99 +1,203 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
-	hue "github.com/ezebunandu/gohue"
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/ezebunandu/gohome/huebridge"
 )
 
-var HueID string
-var HueIPAddress string
-
-type Lights struct {
-	Lights []string `json:"lights"`
+// lightsOf resolves the light_name of a request, answering with a 400 when
+// it is not a configured light or group.
+func lightsOf(w http.ResponseWriter, r *http.Request, cfg *config) (string, []string, bool) {
+	light := r.PathValue("light_name")
+	lights, ok := cfg.resolve(light)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("'%s' is not a valid light name\n", light)))
+		log.Printf("ERROR: invalid light name '%s' is invalid\n", light)
+	}
+	return light, lights, ok
 }
 
-// map the url-friendly name for lights to their actual names on the hue bridge
-var lightMappings = map[string]string{
-	"lamp_stand_1":   "Lamp Stand 1",
-	"lamp_stand_2":   "Lamp Stand 2",
-	"tv_strip_light": "TV Strip Light",
+// durationOf parses the ?duration= of a request, zero when there is none,
+// answering with a 400 when it is invalid.
+func durationOf(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("duration")
+	if v == "" {
+		return 0, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid duration '%s', must be like 15m or 1h30m\n", v)))
+		return 0, false
+	}
+	return d, true
 }
 
-func startColorloop(l string) {
-	log.Printf("INFO: starting colorloop for : %s", l)
-	bridge, err := hue.NewBridge(HueIPAddress)
-	if err != nil {
-		log.Printf("ERROR: invalid hue ip address: %s\n", HueIPAddress)
-	}
-	if err = bridge.Login(HueID); err != nil {
-		log.Printf("ERROR: invalid hue ip address: %s\n", HueID)
+// writeJSON answers with v as JSON and the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("ERROR:", err)
 	}
-	light, err := bridge.GetLightByName(l)
+}
+
+// submitJob queues a job and answers with it, or with a 503 when the queue
+// is full.
+func submitJob(w http.ResponseWriter, jobs *jobQueue, action, target string, lights []string, do func(light string) error) {
+	j, err := jobs.submit(action, target, lights, do)
 	if err != nil {
-		log.Printf("ERROR: could not connect to light: %s\n", l)
-	}
-	if err = light.ColorLoop(true); err != nil {
-		log.Printf("ERROR: could not activate colorloop: %#v\n", err)
+		log.Println("ERROR:", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
 }
 
-func newMux() http.Handler {
+func newMux(cfg *config, lp *looper, fx *effects, jobs *jobQueue, auth *httpauth.Authenticator) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /colorloop/{light_name}", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("POST /colorloop/{light_name}", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Received post request")
-		light := r.PathValue("light_name")
-		w.Header().Set("Content-Type", "application/json")
-		l, ok := lightMappings[light]
+		d, ok := durationOf(w, r)
+		if !ok {
+			return
+		}
+		light, lights, ok := lightsOf(w, r, cfg)
 		if !ok {
+			return
+		}
+		log.Printf("INFO: starting colorloop for %s\n", light)
+		submitJob(w, jobs, "start", light, lights, func(l string) error {
+			return lp.start(l, d, fx.stop(l, errEffectReplaced))
+		})
+	}))
+
+	mux.Handle("DELETE /colorloop/{light_name}", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+		light, lights, ok := lightsOf(w, r, cfg)
+		if !ok {
+			return
+		}
+		log.Printf("INFO: stopping colorloop for %s\n", light)
+		submitJob(w, jobs, "stop", light, lights, lp.stop)
+	}))
+
+	mux.Handle("POST /effects/{effect}/{light_name}", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("effect")
+		if _, ok := cfg.Effects[name]; !ok {
 			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(fmt.Sprintf("'%s' is not a valid light name\n", light)))
-			log.Printf("ERROR: invalid light name '%s' is invalid\n", light)
+			w.Write([]byte(fmt.Sprintf("'%s' is not a valid effect\n", name)))
+			log.Printf("ERROR: invalid effect name '%s'\n", name)
+			return
+		}
+		d, ok := durationOf(w, r)
+		if !ok {
+			return
+		}
+		light, lights, ok := lightsOf(w, r, cfg)
+		if !ok {
 			return
-		} else {
-			w.WriteHeader(http.StatusAccepted)
-			log.Printf("INFO: starting colorloop for %s\n", light)
-			w.Write([]byte(fmt.Sprintf("Received request to enable colorlooper for '%s'\n", light)))
-			startColorloop(l)
 		}
-	})
-	mux.HandleFunc("POST /colorloop/all", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Received  request")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte("Received request to enable colorlooper for all lights"))
-		log.Println("INFO: starting colorloop for all lights")
-		for _, l := range lightMappings {
-			if l == "TV Strip Light" {
-				continue
+		log.Printf("INFO: starting effect %s for %s\n", name, light)
+		submitJob(w, jobs, "effect "+name, light, lights, func(l string) error {
+			return fx.start(name, l, d, lp.forget(l))
+		})
+	}))
+
+	mux.Handle("DELETE /effects/{light_name}", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+		light, lights, ok := lightsOf(w, r, cfg)
+		if !ok {
+			return
+		}
+		log.Printf("INFO: stopping effects for %s\n", light)
+		submitJob(w, jobs, "stop effect", light, lights, func(l string) error {
+			fx.stop(l, errEffectStopped)
+			return nil
+		})
+	}))
+
+	mux.Handle("GET /effects", auth.RequireFunc(httpauth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"effects": fx.names(),
+			"running": fx.list(),
+		})
+	}))
+
+	mux.Handle("GET /jobs/{id}", auth.RequireFunc(httpauth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		j, ok := jobs.get(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "unknown job", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	}))
+
+	mux.Handle("GET /colorloop/{light_name}", auth.RequireFunc(httpauth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		_, lights, ok := lightsOf(w, r, cfg)
+		if !ok {
+			return
+		}
+		statuses := []loopStatus{}
+		for _, l := range lights {
+			st, err := lp.status(l)
+			if err != nil {
+				log.Println("ERROR:", err)
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
 			}
-			startColorloop(l)
+			statuses = append(statuses, st)
 		}
-	})
+		writeJSON(w, http.StatusOK, statuses)
+	}))
 	return mux
 }
 
+const (
+	// attempts per light of a job, the bridge drops requests when busy
+	jobAttempts = 4
+	jobBackoff  = time.Second
+)
+
 func main() {
-	var ok bool
-	HueID, ok = os.LookupEnv("HUE_ID")
-	if !ok {
-		log.Println("HUE_ID not set")
+	c := flag.String("c", "config.yml", "Config file")
+	discoverLights := flag.Bool("discover", false, "Print a config generated from the lights on the hue bridge and exit")
+	flag.Parse()
+
+	if *discoverLights {
+		// the config file may not exist yet, the bridge comes from the env
+		if err := discover(os.Getenv("HUE_IP_ADDRESS"), os.Getenv("HUE_ID"), os.Stdout); err != nil {
+			log.Println("ERROR:", err)
+			os.Exit(1)
+		}
+		return
 	}
-	HueIPAddress, ok = os.LookupEnv("HUE_IP_ADDRESS")
-	if !ok {
-		log.Println("HUE_IP_ADDRESS not set")
+
+	cfg, err := newConfig(*c)
+	if err != nil {
+		log.Println("ERROR:", err)
+		os.Exit(1)
 	}
-	if HueID == "" || HueIPAddress == "" {
-		log.Fatal("HUE_ID and HUE_IP_ADDRESS environment variables must be set")
+
+	auth, err := httpauth.Open(cfg.Auth)
+	if err != nil {
+		log.Println("ERROR:", err)
+		os.Exit(1)
 	}
+
+	bridge := huebridge.New(cfg.HueIPAddress, cfg.HueID, huebridge.WithRateLimit(cfg.CommandsPerSecond))
 	s := &http.Server{
 		Addr:         ":3005",
-		Handler:      newMux(),
+		Handler:      newMux(cfg, newLooper(bridge), newEffects(cfg, bridge), newJobQueue(jobAttempts, jobBackoff), auth),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
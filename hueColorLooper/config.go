@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ezebunandu/gohome/httpauth"
+	"gopkg.in/yaml.v3"
+)
+
+// slug of the endpoint acting on every light not excluded from it
+const allSlug = "all"
+
+type light struct {
+	// name of the light on the hue bridge
+	Name string `yaml:"name"`
+	// url-friendly name, derived from the name when empty
+	Slug string `yaml:"slug,omitempty"`
+	// leave the light out of /colorloop/all
+	ExcludeFromAll bool `yaml:"exclude_from_all,omitempty"`
+}
+
+type config struct {
+	HueIPAddress string  `yaml:"hue_ip_address"`
+	HueID        string  `yaml:"hue_id"`
+	Lights       []light `yaml:"lights"`
+	// named sets of light slugs, addressed like a single light
+	Groups map[string][]string `yaml:"groups,omitempty"`
+	// effects started with /effects/{effect}/{light_name}
+	Effects map[string]*effect `yaml:"effects,omitempty"`
+	// most commands sent to the lights, defaults to the 10 a second the
+	// bridge can take
+	CommandsPerSecond int `yaml:"commands_per_second,omitempty"`
+	// keys the API requests are authenticated with
+	Auth httpauth.Config `yaml:"auth,omitempty"`
+}
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a light name into its url-friendly form, "Lamp Stand 1"
+// becomes "lamp_stand_1".
+func slugify(name string) string {
+	return strings.Trim(nonSlugChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+func newConfig(configFile string) (*config, error) {
+	cf, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer cf.Close()
+
+	var cfg config
+	if err := yaml.NewDecoder(cf).Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	//Override HUE ID and IP address with env vars
+	if hueID, ok := os.LookupEnv("HUE_ID"); ok {
+		cfg.HueID = hueID
+	}
+	if hueIPAddress, ok := os.LookupEnv("HUE_IP_ADDRESS"); ok {
+		cfg.HueIPAddress = hueIPAddress
+	}
+	if cfg.HueID == "" || cfg.HueIPAddress == "" {
+		return nil, errors.New("hue_id and hue_ip_address, or the HUE_ID and HUE_IP_ADDRESS env vars, must be set")
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate fills in missing slugs and defaults, and checks that every slug
+// is unique, every group member is a configured light and every effect is
+// valid.
+func (cfg *config) validate() error {
+	if len(cfg.Lights) == 0 {
+		return errors.New("at least one light must be configured")
+	}
+
+	slugs := make(map[string]bool)
+	for i := range cfg.Lights {
+		l := &cfg.Lights[i]
+		if l.Name == "" {
+			return fmt.Errorf("light %d has no name", i+1)
+		}
+		if l.Slug == "" {
+			l.Slug = slugify(l.Name)
+		}
+		if l.Slug == allSlug || slugs[l.Slug] {
+			return fmt.Errorf("light %q: slug %q is reserved or already used", l.Name, l.Slug)
+		}
+		slugs[l.Slug] = true
+	}
+	for group, members := range cfg.Groups {
+		if group == allSlug || slugs[group] {
+			return fmt.Errorf("group %q: name is reserved or already used by a light", group)
+		}
+		for _, m := range members {
+			if cfg.light(m) == nil {
+				return fmt.Errorf("group %q: unknown light %q", group, m)
+			}
+		}
+	}
+	for name, e := range cfg.Effects {
+		if e == nil {
+			return fmt.Errorf("effect %q has no type", name)
+		}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("effect %q: %w", name, err)
+		}
+	}
+	if cfg.CommandsPerSecond == 0 {
+		cfg.CommandsPerSecond = 10
+	}
+	if cfg.CommandsPerSecond < 0 {
+		return errors.New("commands_per_second cannot be negative")
+	}
+	return nil
+}
+
+func (cfg *config) light(slug string) *light {
+	for i := range cfg.Lights {
+		if cfg.Lights[i].Slug == slug {
+			return &cfg.Lights[i]
+		}
+	}
+	return nil
+}
+
+// resolve returns the bridge names of the lights a slug stands for: a
+// single light, the members of a group or, for "all", every light not
+// excluded from it.
+func (cfg *config) resolve(slug string) ([]string, bool) {
+	var names []string
+	switch members, isGroup := cfg.Groups[slug]; {
+	case slug == allSlug:
+		for _, l := range cfg.Lights {
+			if !l.ExcludeFromAll {
+				names = append(names, l.Name)
+			}
+		}
+	case isGroup:
+		for _, m := range members {
+			names = append(names, cfg.light(m).Name)
+		}
+	default:
+		l := cfg.light(slug)
+		if l == nil {
+			return nil, false
+		}
+		names = append(names, l.Name)
+	}
+	return names, true
+}
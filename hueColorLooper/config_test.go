@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	hue "github.com/ezebunandu/gohue"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSlugify(t *testing.T) {
+	t.Parallel()
+	for name, want := range map[string]string{
+		"Lamp Stand 1":         "lamp_stand_1",
+		"TV Strip Light":       "tv_strip_light",
+		"  Hallway / Ceiling ": "hallway_ceiling",
+	} {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNewConfig__ResolvesLightsGroupsAndExclusions(t *testing.T) {
+	t.Setenv("HUE_ID", "secret")
+	cfg, err := newConfig(writeConfig(t, `
+hue_ip_address: "192.168.1.2"
+lights:
+  - name: "Lamp Stand 1"
+  - name: "Lamp Stand 2"
+    slug: "reading"
+  - name: "TV Strip Light"
+    exclude_from_all: true
+groups:
+  lamps: ["lamp_stand_1", "reading"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HueID != "secret" {
+		t.Errorf("want hue id from the env, got %q", cfg.HueID)
+	}
+
+	tests := []struct {
+		slug string
+		want []string
+	}{
+		{"lamp_stand_1", []string{"Lamp Stand 1"}},
+		{"reading", []string{"Lamp Stand 2"}},
+		{"tv_strip_light", []string{"TV Strip Light"}},
+		{"lamps", []string{"Lamp Stand 1", "Lamp Stand 2"}},
+		{"all", []string{"Lamp Stand 1", "Lamp Stand 2"}},
+	}
+	for _, tt := range tests {
+		if got, ok := cfg.resolve(tt.slug); !ok || !slices.Equal(got, tt.want) {
+			t.Errorf("resolve(%q) = %v, want %v", tt.slug, got, tt.want)
+		}
+	}
+	if _, ok := cfg.resolve("lamp_stand_2"); ok {
+		t.Error("want the derived slug replaced by the configured one")
+	}
+}
+
+func TestNewConfig__ParsesEffects(t *testing.T) {
+	t.Setenv("HUE_ID", "secret")
+	cfg, err := newConfig(writeConfig(t, `
+hue_ip_address: "192.168.1.2"
+lights:
+  - name: "Lamp Stand 1"
+effects:
+  party:
+    type: cycle
+    colors: [red, purple, blue]
+    fade: 2s
+    hold: 1m
+  wake_up:
+    type: sunrise
+    duration: 30m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	party := cfg.Effects["party"]
+	if party.Fade != 2*time.Second || party.Hold != time.Minute || len(party.Colors) != 3 {
+		t.Errorf("party = %+v", party)
+	}
+	if wake := cfg.Effects["wake_up"]; wake.Duration != 30*time.Minute || wake.MaxBrightness != 100 {
+		t.Errorf("wake_up = %+v", wake)
+	}
+	if cfg.CommandsPerSecond != 10 {
+		t.Errorf("commands per second = %d, want the default of 10", cfg.CommandsPerSecond)
+	}
+}
+
+func TestNewConfig__RejectsInvalidConfigs(t *testing.T) {
+	t.Setenv("HUE_ID", "secret")
+	tests := map[string]string{
+		"no lights":            `hue_ip_address: "192.168.1.2"`,
+		"duplicate slug":       "hue_ip_address: x\nlights: [{name: a}, {name: b, slug: a}]",
+		"reserved slug":        "hue_ip_address: x\nlights: [{name: All}]",
+		"unknown group light":  "hue_ip_address: x\nlights: [{name: a}]\ngroups: {g: [b]}",
+		"group named as light": "hue_ip_address: x\nlights: [{name: a}]\ngroups: {a: [a]}",
+		"invalid effect":       "hue_ip_address: x\nlights: [{name: a}]\neffects: {e: {type: strobe}}",
+	}
+	for name, content := range tests {
+		if _, err := newConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestDiscoveredConfig(t *testing.T) {
+	t.Parallel()
+	var color, white, ambiance, strip hue.Light
+	color.Name, color.Type, color.State.ColorMode = "Lamp Stand 1", "Extended color light", "xy"
+	white.Name, white.Type = "Garage Bulb", "Dimmable light"
+	// color temperatures only, it cannot colorloop
+	ambiance.Name, ambiance.Type, ambiance.State.ColorMode = "Hall Bulb", "Color temperature light", "ct"
+	strip.Name, strip.Type, strip.State.ColorMode = "TV Strip Light", "Color light", "hs"
+
+	cfg := discoveredConfig("192.168.1.2", []hue.Light{strip, white, ambiance, color})
+	want := []light{{Name: "Lamp Stand 1", Slug: "lamp_stand_1"}, {Name: "TV Strip Light", Slug: "tv_strip_light"}}
+	if !slices.Equal(cfg.Lights, want) {
+		t.Errorf("got lights %+v, want %+v", cfg.Lights, want)
+	}
+}
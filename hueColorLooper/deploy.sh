@@ -3,6 +3,12 @@
 # Exit on any error
 set -e
 
+# Script must be run from the hueColorLooper directory
+if [[ ! -f "config.yml" ]]; then
+    echo "Error: config.yml not found. Please run this script from the hueColorLooper directory"
+    exit 1
+fi
+
 # Check if HUE_ID environment variable is set
 if [[ -z "${HUE_ID}" ]]; then
     echo "Error: HUE_ID environment variable is not set"
@@ -15,6 +21,19 @@ if [[ -z "${HUE_IP_ADDRESS}" ]]; then
     exit 1
 fi
 
+# Check the API keys file is set, see ../httpauth
+if [[ -z "${API_KEYS_FILE}" || ! -f "${API_KEYS_FILE}" ]]; then
+    echo "Error: API_KEYS_FILE environment variable is not set to the API keys file"
+    exit 1
+fi
+
+# Create manifests/base directory if it doesn't exist
+mkdir -p manifests/base
+
+# Copy config.yml to manifests/base
+echo "Copying config.yml to manifests/base..."
+cp config.yml manifests/base/
+
 # Create base64 encoded HUE_ID
 export HUE_ID_BASE64=$(echo -n $HUE_ID | base64)
 
@@ -26,6 +45,10 @@ echo "Applying secret and manifests..."
 sed -i -e "s#{HUE_ID}#${HUE_ID_BASE64}#g" manifests/secrets.yaml
 sed -i -e "s#{HUE_IP_ADDRESS}#${HUE_IP_ADDRESS_BASE64}#g" manifests/secrets.yaml
 
+# Create base64 encoded API keys file
+export API_KEYS_BASE64=$(base64 -w0 < "${API_KEYS_FILE}")
+sed -i -e "s#\${API_KEYS}#${API_KEYS_BASE64}#g" manifests/secrets.yaml
+
 # Apply Kubernetes manifests using kustomize
 echo "Applying Kubernetes manifests..."
 kubectl apply -k manifests/
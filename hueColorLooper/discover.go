@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/ezebunandu/gohome/huebridge"
+	hue "github.com/ezebunandu/gohue"
+	"gopkg.in/yaml.v3"
+)
+
+// types of the lights able to colorloop, white ambiance lights report a
+// color mode too but only have color temperatures
+var colorLightTypes = []string{"Extended color light", "Color light"}
+
+// discoveredConfig builds a config listing the lights, with their slugs.
+// Lights without color support are left out: they cannot colorloop.
+func discoveredConfig(ipAddress string, lights []hue.Light) *config {
+	cfg := &config{HueIPAddress: ipAddress}
+	for _, l := range lights {
+		if !slices.Contains(colorLightTypes, l.Type) {
+			continue
+		}
+		cfg.Lights = append(cfg.Lights, light{Name: l.Name, Slug: slugify(l.Name)})
+	}
+	sort.Slice(cfg.Lights, func(i, j int) bool {
+		return cfg.Lights[i].Name < cfg.Lights[j].Name
+	})
+	return cfg
+}
+
+// discover writes a config generated from the lights the bridge reports.
+// The hue id is left out, it is a secret better kept in the HUE_ID env var.
+func discover(ipAddress, hueID string, w io.Writer) error {
+	lights, err := huebridge.New(ipAddress, hueID).Lights(context.Background())
+	if err != nil {
+		return fmt.Errorf("could not list lights: %w", err)
+	}
+
+	cfg := discoveredConfig(ipAddress, lights)
+	fmt.Fprintf(w, "# Generated from the %d color lights of the hue bridge at %s.\n", len(cfg.Lights), ipAddress)
+	fmt.Fprintln(w, "# Set exclude_from_all on lights to leave out of /colorloop/all and")
+	fmt.Fprintln(w, "# add groups of slugs as needed.")
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ezebunandu/gohome/huebridge"
+	hue "github.com/ezebunandu/gohue"
+)
+
+const (
+	// fades through colors, holding each one for a while
+	effectCycle = "cycle"
+	// flickers between random brightnesses
+	effectCandle = "candle"
+	// ramps brightness up and color through the colors over the duration,
+	// the light stays on at the end
+	effectSunrise = "sunrise"
+	// fades brightness up and down
+	effectPulse = "pulse"
+
+	// most steps of a sunrise, the bridge fades between them
+	sunriseSteps = 60
+)
+
+// colors the effects can use, by name
+var namedColors = map[string]*[2]float32{
+	"red":    hue.RED,
+	"yellow": hue.YELLOW,
+	"orange": hue.ORANGE,
+	"green":  hue.GREEN,
+	"cyan":   hue.CYAN,
+	"blue":   hue.BLUE,
+	"purple": hue.PURPLE,
+	"pink":   hue.PINK,
+	"white":  hue.WHITE,
+}
+
+// effect is a sequence of light states defined in the config.
+type effect struct {
+	Type string `yaml:"type"`
+	// names of the colors used, the first one for candle and pulse
+	Colors []string `yaml:"colors,omitempty"`
+	// how long each change takes, the bridge fading in between
+	Fade time.Duration `yaml:"fade,omitempty"`
+	// how long a cycle stays on each color
+	Hold time.Duration `yaml:"hold,omitempty"`
+	// how long a sunrise ramps up for, or how long other effects run
+	// for, until stopped when zero
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// brightness range in percent, the flicker of a candle, the low and
+	// high of a pulse, the end of a sunrise
+	MinBrightness int `yaml:"min_brightness,omitempty"`
+	MaxBrightness int `yaml:"max_brightness,omitempty"`
+}
+
+// validate checks the effect and fills in the defaults of its type.
+func (e *effect) validate() error {
+	var colors []string
+	var fade time.Duration
+	minBri := 10
+	switch e.Type {
+	case effectCycle:
+		if len(e.Colors) < 2 {
+			return fmt.Errorf("a %s needs at least 2 colors", e.Type)
+		}
+		fade = time.Second
+	case effectCandle:
+		colors, fade, minBri = []string{"orange"}, 300*time.Millisecond, 30
+	case effectSunrise:
+		if e.Duration <= 0 {
+			return fmt.Errorf("a %s needs a duration", e.Type)
+		}
+		colors = []string{"red", "orange", "white"}
+	case effectPulse:
+		colors, fade = []string{"white"}, time.Second
+	default:
+		return fmt.Errorf("unknown effect type %q, must be %s, %s, %s or %s", e.Type, effectCycle, effectCandle, effectSunrise, effectPulse)
+	}
+	if len(e.Colors) == 0 {
+		e.Colors = colors
+	}
+	if e.Fade == 0 {
+		e.Fade = fade
+	}
+	if e.MinBrightness == 0 {
+		e.MinBrightness = minBri
+	}
+	if e.MaxBrightness == 0 {
+		e.MaxBrightness = 100
+	}
+
+	for _, c := range e.Colors {
+		if _, ok := colorByName(c); !ok {
+			return fmt.Errorf("unknown color %q", c)
+		}
+	}
+	if e.Fade < 0 || e.Hold < 0 || e.Duration < 0 {
+		return fmt.Errorf("fade, hold and duration cannot be negative")
+	}
+	if e.MinBrightness < 1 || e.MaxBrightness > 100 || e.MinBrightness > e.MaxBrightness {
+		return fmt.Errorf("brightness must be between 1 and 100, min_brightness no more than max_brightness")
+	}
+	return nil
+}
+
+func colorByName(name string) (*[2]float32, bool) {
+	c, ok := namedColors[name]
+	return c, ok
+}
+
+// bri converts a brightness in percent to the bridge's 1-254.
+func bri(percent int) uint8 {
+	return uint8(max(1, percent*254/100))
+}
+
+// effectState is a change of light state with a fade. It is sent instead
+// of hue.LightState, which has the transition time as a string the bridge
+// rejects.
+type effectState struct {
+	On     bool        `json:"on"`
+	Bri    uint8       `json:"bri,omitempty"`
+	XY     *[2]float32 `json:"xy,omitempty"`
+	Effect string      `json:"effect,omitempty"`
+	// in steps of 100ms
+	TransitionTime uint16 `json:"transitiontime"`
+}
+
+func newEffectState(xy *[2]float32, percent int, fade time.Duration) effectState {
+	return effectState{On: true, Bri: bri(percent), XY: xy, TransitionTime: uint16(fade / (100 * time.Millisecond))}
+}
+
+// step returns the i-th change of the effect and how long until the next
+// one, false once the effect is over. d is how long a sunrise ramps up for.
+func (e *effect) step(i int, d time.Duration) (effectState, time.Duration, bool) {
+	first, _ := colorByName(e.Colors[0])
+	switch e.Type {
+	case effectCycle:
+		xy, _ := colorByName(e.Colors[i%len(e.Colors)])
+		return newEffectState(xy, e.MaxBrightness, e.Fade), e.Fade + e.Hold, true
+	case effectCandle:
+		fade := time.Duration(rand.Int64N(int64(e.Fade))) + e.Fade/2
+		percent := e.MinBrightness + rand.IntN(e.MaxBrightness-e.MinBrightness+1)
+		return newEffectState(first, percent, fade), fade, true
+	case effectPulse:
+		percent := e.MaxBrightness
+		if i%2 == 1 {
+			percent = e.MinBrightness
+		}
+		return newEffectState(first, percent, e.Fade), e.Fade, true
+	case effectSunrise:
+		steps := min(sunriseSteps, max(1, int(d/time.Second)))
+		if i > steps {
+			return effectState{}, 0, false
+		}
+		if i == 0 {
+			return newEffectState(first, 1, 0), 0, true
+		}
+		stepTime := d / time.Duration(steps)
+		frac := float32(i) / float32(steps)
+		percent := 1 + int(frac*float32(e.MaxBrightness-1))
+		return newEffectState(e.colorAt(frac), percent, stepTime), stepTime, true
+	}
+	return effectState{}, 0, false
+}
+
+// colorAt blends the colors of the effect, frac going from 0 at the first
+// one to 1 at the last.
+func (e *effect) colorAt(frac float32) *[2]float32 {
+	pos := frac * float32(len(e.Colors)-1)
+	k := min(int(pos), len(e.Colors)-2)
+	if k < 0 {
+		c, _ := colorByName(e.Colors[0])
+		return c
+	}
+	a, _ := colorByName(e.Colors[k])
+	b, _ := colorByName(e.Colors[k+1])
+	t := pos - float32(k)
+	return &[2]float32{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t}
+}
+
+var (
+	// an effect reached its duration, or was stopped through the API
+	errEffectOver    = errors.New("effect over")
+	errEffectStopped = errors.New("effect stopped")
+	// another effect started on the light
+	errEffectReplaced = errors.New("effect replaced")
+)
+
+// runningEffect is an effect going on on a light.
+type runningEffect struct {
+	Effect  string     `json:"effect"`
+	Light   string     `json:"light"`
+	Started time.Time  `json:"started"`
+	Until   *time.Time `json:"until,omitempty"`
+
+	// state of the light before the effect, or the one it replaced
+	previous hue.LightState
+	cancel   context.CancelCauseFunc
+	done     chan struct{}
+}
+
+// effects runs the effects of the config, at most one per light.
+type effects struct {
+	cfg    *config
+	bridge *huebridge.Client
+
+	mu      sync.Mutex
+	running map[string]*runningEffect
+}
+
+func newEffects(cfg *config, bridge *huebridge.Client) *effects {
+	return &effects{cfg: cfg, bridge: bridge, running: make(map[string]*runningEffect)}
+}
+
+func (fx *effects) names() []string {
+	names := []string{}
+	for name := range fx.cfg.Effects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// start runs an effect on a light, in place of the one it may be running.
+// d overrides the duration of the effect when not zero. previous is the
+// state of the light from before a loop the effect replaces, if any. The
+// light goes back to it, or to the state from before a replaced effect,
+// rather than to where they left it.
+func (fx *effects) start(name, lightName string, d time.Duration, previous *hue.LightState) error {
+	e, ok := fx.cfg.Effects[name]
+	if !ok {
+		return fmt.Errorf("unknown effect %q", name)
+	}
+	if d == 0 {
+		d = e.Duration
+	}
+	if replaced := fx.stop(lightName, errEffectReplaced); replaced != nil {
+		previous = replaced
+	}
+	if previous == nil {
+		light, err := fx.bridge.Light(context.Background(), lightName)
+		if err != nil {
+			return err
+		}
+		s := savedState(light)
+		previous = &s
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r := &runningEffect{Effect: name, Light: lightName, Started: time.Now(), previous: *previous, cancel: cancel, done: make(chan struct{})}
+	if d > 0 && e.Type != effectSunrise {
+		until := r.Started.Add(d)
+		r.Until = &until
+		timer := time.AfterFunc(d, func() { cancel(errEffectOver) })
+		go func() {
+			<-r.done
+			timer.Stop()
+		}()
+	}
+	fx.mu.Lock()
+	fx.running[lightName] = r
+	fx.mu.Unlock()
+
+	log.Printf("INFO: starting effect %s for %s", name, lightName)
+	go fx.run(ctx, r, e, d)
+	return nil
+}
+
+func (fx *effects) run(ctx context.Context, r *runningEffect, e *effect, d time.Duration) {
+	defer close(r.done)
+	defer func() {
+		fx.mu.Lock()
+		defer fx.mu.Unlock()
+		if fx.running[r.Light] == r {
+			delete(fx.running, r.Light)
+		}
+	}()
+	completed := false
+	for i := 0; ctx.Err() == nil; i++ {
+		state, wait, ok := e.step(i, d)
+		if !ok {
+			completed = true
+			break
+		}
+		if i == 0 {
+			state.Effect = "none"
+		}
+		if err := fx.bridge.SetState(ctx, r.Light, state); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("ERROR: effect %s on %s: %v", r.Effect, r.Light, err)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	// A sunrise stays up once over, and a light is left to the effect
+	// replacing this one, else it goes back to how it was
+	if completed || context.Cause(ctx) == errEffectReplaced {
+		log.Printf("INFO: effect %s on %s is over", r.Effect, r.Light)
+		return
+	}
+	log.Printf("INFO: effect %s on %s is over, restoring the light", r.Effect, r.Light)
+	if err := restore(context.Background(), fx.bridge, r.Light, r.previous); err != nil {
+		log.Printf("ERROR: could not restore %s after effect %s: %v", r.Light, r.Effect, err)
+	}
+}
+
+// stop ends the effect running on a light, if any, and waits for it to be
+// over. Unless replaced, the light goes back to its state from before the
+// effect, which is returned for what replaces it to restore in turn. It
+// returns nil when no effect was running.
+func (fx *effects) stop(lightName string, cause error) *hue.LightState {
+	fx.mu.Lock()
+	r, ok := fx.running[lightName]
+	fx.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	r.cancel(cause)
+	<-r.done
+	previous := r.previous
+	return &previous
+}
+
+func (fx *effects) list() []runningEffect {
+	fx.mu.Lock()
+	defer fx.mu.Unlock()
+	list := []runningEffect{}
+	for _, r := range fx.running {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Light < list[j].Light })
+	return list
+}
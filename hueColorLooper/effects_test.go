@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	hue "github.com/ezebunandu/gohue"
+)
+
+func TestEffectValidate(t *testing.T) {
+	t.Parallel()
+	candle := &effect{Type: effectCandle}
+	if err := candle.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if candle.Colors[0] != "orange" || candle.Fade != 300*time.Millisecond || candle.MinBrightness != 30 || candle.MaxBrightness != 100 {
+		t.Errorf("candle defaults = %+v", candle)
+	}
+
+	for name, e := range map[string]*effect{
+		"unknown type":       {Type: "strobe"},
+		"cycle of one color": {Type: effectCycle, Colors: []string{"red"}},
+		"unknown color":      {Type: effectPulse, Colors: []string{"mauve"}},
+		"sunrise no end":     {Type: effectSunrise},
+		"brightness range":   {Type: effectPulse, MinBrightness: 80, MaxBrightness: 20},
+	} {
+		if err := e.validate(); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestEffectStep(t *testing.T) {
+	t.Parallel()
+	cycle := &effect{Type: effectCycle, Colors: []string{"red", "blue"}, Fade: time.Second, Hold: 2 * time.Second}
+	if err := cycle.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []*[2]float32{hue.RED, hue.BLUE, hue.RED} {
+		state, wait, ok := cycle.step(i, 0)
+		if !ok || *state.XY != *want || state.TransitionTime != 10 || wait != 3*time.Second {
+			t.Errorf("cycle step %d = %+v, %s, %t", i, state, wait, ok)
+		}
+	}
+
+	pulse := &effect{Type: effectPulse, MinBrightness: 20, MaxBrightness: 60}
+	if err := pulse.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []uint8{bri(60), bri(20), bri(60)} {
+		if state, _, _ := pulse.step(i, 0); state.Bri != want {
+			t.Errorf("pulse step %d brightness = %d, want %d", i, state.Bri, want)
+		}
+	}
+
+	sunrise := &effect{Type: effectSunrise, Duration: 30 * time.Minute}
+	if err := sunrise.validate(); err != nil {
+		t.Fatal(err)
+	}
+	state, _, _ := sunrise.step(0, sunrise.Duration)
+	if state.Bri != bri(1) || *state.XY != *hue.RED {
+		t.Errorf("sunrise starts at %+v", state)
+	}
+	state, wait, ok := sunrise.step(sunriseSteps, sunrise.Duration)
+	if !ok || state.Bri != 254 || *state.XY != *hue.WHITE || wait != 30*time.Second {
+		t.Errorf("sunrise ends at %+v after %s", state, wait)
+	}
+	if _, _, ok := sunrise.step(sunriseSteps+1, sunrise.Duration); ok {
+		t.Error("sunrise goes on after its last step")
+	}
+}
+
+func waitEffects(t *testing.T, fx *effects) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for len(fx.list()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("effects still running: %+v", fx.list())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEffects__RestoreLightWhenOver(t *testing.T) {
+	bridge, cfg, lp := newTestLooper(t)
+	fx := newEffects(cfg, lp.bridge)
+
+	if err := fx.start("party", "Lamp Stand 1", 100*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.start("party", "Lamp Stand 2", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	running := fx.list()
+	if len(running) != 2 || running[0].Until == nil || running[1].Until != nil {
+		t.Fatalf("running = %+v", running)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if l := bridge.light("Lamp Stand 2"); !l.State.On {
+		t.Errorf("Lamp Stand 2 was not turned on by the effect: %+v", l.State)
+	}
+
+	fx.stop("Lamp Stand 2", errEffectStopped)
+	waitEffects(t, fx)
+	l1 := bridge.light("Lamp Stand 1")
+	if !l1.State.On || l1.State.Bri != 120 || l1.State.XY != [2]float32{0.4, 0.4} {
+		t.Errorf("Lamp Stand 1 was not restored: %+v", l1.State)
+	}
+	if l2 := bridge.light("Lamp Stand 2"); l2.State.On || l2.State.Bri != 200 {
+		t.Errorf("Lamp Stand 2 was not restored: %+v", l2.State)
+	}
+}
+
+func TestEffects__ReplacedEffectLeavesLight(t *testing.T) {
+	bridge, cfg, lp := newTestLooper(t)
+	fx := newEffects(cfg, lp.bridge)
+
+	if err := fx.start("party", "Lamp Stand 2", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.start("dawn", "Lamp Stand 2", 50*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitEffects(t, fx)
+	if l := bridge.light("Lamp Stand 2"); !l.State.On || l.State.Bri != 254 || l.State.XY != *hue.WHITE {
+		t.Errorf("Lamp Stand 2 did not stay up after the sunrise: %+v", l.State)
+	}
+}
+
+func TestEffects__RestoreStateFromBeforeReplacedEffects(t *testing.T) {
+	bridge, cfg, lp := newTestLooper(t)
+	fx := newEffects(cfg, lp.bridge)
+	restored := func(step string) {
+		t.Helper()
+		if l := bridge.light("Lamp Stand 1"); !l.State.On || l.State.Bri != 120 || l.State.XY != [2]float32{0.4, 0.4} || l.State.Effect == "colorloop" {
+			t.Errorf("%s: Lamp Stand 1 was not restored: %+v", step, l.State)
+		}
+	}
+
+	// effect, effect, stop
+	if err := fx.start("party", "Lamp Stand 1", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := fx.start("party", "Lamp Stand 1", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	fx.stop("Lamp Stand 1", errEffectStopped)
+	restored("effect replacing an effect")
+
+	// loop, effect, loop, stop, as the API does
+	if err := lp.start("Lamp Stand 1", 0, fx.stop("Lamp Stand 1", errEffectReplaced)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.start("party", "Lamp Stand 1", 0, lp.forget("Lamp Stand 1")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := lp.start("Lamp Stand 1", 0, fx.stop("Lamp Stand 1", errEffectReplaced)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lp.stop("Lamp Stand 1"); err != nil {
+		t.Fatal(err)
+	}
+	restored("loop replacing an effect replacing a loop")
+}
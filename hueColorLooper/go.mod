@@ -2,6 +2,18 @@ module github.com/ezebunandu/gohome/colorlooper
 
 go 1.23.7
 
-require github.com/ezebunandu/gohue v0.0.0-20241219053637-5238c4a2e098
+require (
+	github.com/ezebunandu/gohome/httpauth v0.0.0
+	github.com/ezebunandu/gohome/huebridge v0.0.0
+	github.com/ezebunandu/gohue v0.0.0-20241219053637-5238c4a2e098
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require github.com/collinux/gohue v0.0.0-20191209235909-5684411cfded // indirect
+
+// the hue bridge client and API authentication are shared with the other
+// hue services
+replace (
+	github.com/ezebunandu/gohome/httpauth => ../httpauth
+	github.com/ezebunandu/gohome/huebridge => ../huebridge
+)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ezebunandu/gohome/huebridge"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+
+	// finished jobs kept for GET /jobs/{id}, the oldest go first
+	keptJobs = 100
+	// jobs waiting for the worker before requests are turned down
+	queuedJobs = 100
+)
+
+var errQueueFull = errors.New("too many jobs queued, try again later")
+
+// lightResult is the outcome of a job for one of its lights.
+type lightResult struct {
+	Light    string `json:"light"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// error of the last attempt
+	Error string `json:"error,omitempty"`
+}
+
+// job is a request acting on the lights of a slug, carried out in the
+// background.
+type job struct {
+	ID       string        `json:"id"`
+	Action   string        `json:"action"`
+	Target   string        `json:"target"`
+	Status   string        `json:"status"`
+	Created  time.Time     `json:"created"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Lights   []lightResult `json:"lights"`
+
+	do func(light string) error
+}
+
+func (j *job) snapshot() job {
+	c := *j
+	c.Lights = append([]lightResult(nil), j.Lights...)
+	c.do = nil
+	return c
+}
+
+// jobQueue runs jobs one at a time, so lights are not sent commands from
+// several requests at once, and retries the bridge calls that fail.
+type jobQueue struct {
+	// attempts per light, and the delay before the first retry, doubling
+	// for each one after
+	attempts int
+	backoff  time.Duration
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	finished []string
+	pending  chan *job
+}
+
+func newJobQueue(attempts int, backoff time.Duration) *jobQueue {
+	q := &jobQueue{
+		attempts: attempts,
+		backoff:  backoff,
+		jobs:     make(map[string]*job),
+		pending:  make(chan *job, queuedJobs),
+	}
+	go q.work()
+	return q
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit queues a job calling do for each light.
+func (q *jobQueue) submit(action, target string, lights []string, do func(light string) error) (job, error) {
+	j := &job{
+		ID:      newJobID(),
+		Action:  action,
+		Target:  target,
+		Status:  jobQueued,
+		Created: time.Now(),
+		do:      do,
+	}
+	for _, l := range lights {
+		j.Lights = append(j.Lights, lightResult{Light: l, Status: jobQueued})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- j:
+	default:
+		return job{}, errQueueFull
+	}
+	q.jobs[j.ID] = j
+	log.Printf("INFO: queued job %s to %s %s", j.ID, action, target)
+	return j.snapshot(), nil
+}
+
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return j.snapshot(), true
+}
+
+func (q *jobQueue) work() {
+	for j := range q.pending {
+		q.mu.Lock()
+		j.Status = jobRunning
+		q.mu.Unlock()
+
+		status := jobSucceeded
+		for i := range j.Lights {
+			if !q.run(j, i) {
+				status = jobFailed
+			}
+		}
+
+		q.mu.Lock()
+		finished := time.Now()
+		j.Status, j.Finished = status, &finished
+		q.finished = append(q.finished, j.ID)
+		if len(q.finished) > keptJobs {
+			delete(q.jobs, q.finished[0])
+			q.finished = q.finished[1:]
+		}
+		q.mu.Unlock()
+		log.Printf("INFO: job %s to %s %s %s", j.ID, j.Action, j.Target, status)
+	}
+}
+
+// retryable tells errors that may go away, like the bridge being busy or
+// unreachable, from those that will not.
+func retryable(err error) bool {
+	return !errors.Is(err, huebridge.ErrLightNotFound) && !errors.Is(err, huebridge.ErrUnauthorized)
+}
+
+// run carries out a job for its i-th light, retrying with backoff.
+func (q *jobQueue) run(j *job, i int) bool {
+	light := j.Lights[i].Light
+	delay := q.backoff
+	for attempt := 1; ; attempt++ {
+		err := j.do(light)
+		giveUp := err != nil && (attempt == q.attempts || !retryable(err))
+
+		q.mu.Lock()
+		r := &j.Lights[i]
+		r.Attempts = attempt
+		r.Status, r.Error = jobSucceeded, ""
+		if err != nil {
+			r.Status, r.Error = jobRunning, err.Error()
+			if giveUp {
+				r.Status = jobFailed
+			}
+		}
+		q.mu.Unlock()
+
+		switch {
+		case err == nil:
+			return true
+		case giveUp:
+			log.Printf("ERROR: job %s gave up on %s after %d attempts: %v", j.ID, light, attempt, err)
+			return false
+		}
+		log.Printf("INFO: job %s failed on %s, retrying in %s: %v", j.ID, light, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ezebunandu/gohome/huebridge"
+)
+
+func waitJob(t *testing.T, jobs *jobQueue, id string) job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		j, ok := jobs.get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if j.Finished != nil {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s never finished: %+v", id, j)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobQueue__RetriesFailedCalls(t *testing.T) {
+	t.Parallel()
+	jobs := newJobQueue(3, time.Millisecond)
+
+	calls := make(map[string]int)
+	j, err := jobs.submit("start", "lamps", []string{"flaky", "broken", "missing", "fine"}, func(light string) error {
+		calls[light]++
+		switch {
+		case light == "missing":
+			return fmt.Errorf("%w: missing", huebridge.ErrLightNotFound)
+		case light == "broken", light == "flaky" && calls[light] < 3:
+			return errors.New("unable to access bridge")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != jobQueued || len(j.Lights) != 4 {
+		t.Errorf("submitted job = %+v", j)
+	}
+
+	j = waitJob(t, jobs, j.ID)
+	if j.Status != jobFailed {
+		t.Errorf("job status = %s, want %s", j.Status, jobFailed)
+	}
+	want := []lightResult{
+		{Light: "flaky", Status: jobSucceeded, Attempts: 3},
+		{Light: "broken", Status: jobFailed, Attempts: 3, Error: "unable to access bridge"},
+		{Light: "missing", Status: jobFailed, Attempts: 1, Error: "light not found: missing"},
+		{Light: "fine", Status: jobSucceeded, Attempts: 1},
+	}
+	for i, r := range j.Lights {
+		if r != want[i] {
+			t.Errorf("light %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestJobQueue__UnknownJob(t *testing.T) {
+	t.Parallel()
+	jobs := newJobQueue(1, time.Millisecond)
+	if _, ok := jobs.get("nope"); ok {
+		t.Error("got a job for an unknown id")
+	}
+}
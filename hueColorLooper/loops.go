@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ezebunandu/gohome/huebridge"
+	hue "github.com/ezebunandu/gohue"
+)
+
+// loop is a colorloop started through the API.
+type loop struct {
+	Light   string    `json:"light"`
+	Started time.Time `json:"started"`
+	// when the light goes back to its previous state, nil when the loop
+	// runs until stopped
+	Until *time.Time `json:"until,omitempty"`
+
+	// state of the light before the loop started
+	previous hue.LightState
+	timer    *time.Timer
+}
+
+// loopStatus is what GET /colorloop reports for a light.
+type loopStatus struct {
+	Light string `json:"light"`
+	On    bool   `json:"on"`
+	// whether the bridge reports the light as colorlooping, which it may
+	// do for loops started from elsewhere, e.g. the hue app
+	Looping bool `json:"looping"`
+	// the loop started through the API, if any
+	Loop *loop `json:"loop,omitempty"`
+}
+
+// looper keeps track of the colorloops it started, and ends the timed ones
+// by putting the lights back the way they were.
+type looper struct {
+	bridge *huebridge.Client
+
+	mu    sync.Mutex
+	loops map[string]*loop
+}
+
+func newLooper(bridge *huebridge.Client) *looper {
+	return &looper{bridge: bridge, loops: make(map[string]*loop)}
+}
+
+// savedState is the state restoring the color and brightness of a light.
+func savedState(light hue.Light) hue.LightState {
+	s := hue.LightState{On: light.State.On, Bri: light.State.Bri}
+	switch light.State.ColorMode {
+	case "xy":
+		xy := light.State.XY
+		s.XY = &xy
+	case "ct":
+		s.CT = uint16(light.State.CT)
+	case "hs":
+		s.Hue, s.Sat = light.State.Hue, light.State.Saturation
+	}
+	return s
+}
+
+// restore ends a loop or effect and sets the color and brightness back. The light
+// has to be on for them to be set, it is turned back off afterwards if it
+// was off before.
+func restore(ctx context.Context, bridge *huebridge.Client, name string, previous hue.LightState) error {
+	state := previous
+	state.On = true
+	state.Effect = "none"
+	if err := bridge.SetState(ctx, name, state); err != nil {
+		return err
+	}
+	if !previous.On {
+		return bridge.Off(ctx, name)
+	}
+	return nil
+}
+
+// start puts a light in colorloop mode, for d or until stopped when d is
+// zero. Starting a light already looping changes how long it loops for,
+// it is still restored to its state from before the first start. previous
+// is the state of the light from before an effect the loop replaces, if
+// any, restored in place of the effect's.
+func (lp *looper) start(name string, d time.Duration, previous *hue.LightState) error {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	log.Printf("INFO: starting colorloop for : %s", name)
+	ctx := context.Background()
+	light, err := lp.bridge.Light(ctx, name)
+	if err != nil {
+		return err
+	}
+	l, looping := lp.loops[name]
+	if !looping {
+		l = &loop{Light: name, Started: time.Now(), previous: savedState(light)}
+		if previous != nil {
+			l.previous = *previous
+		}
+	}
+	if err := lp.bridge.SetState(ctx, name, hue.LightState{On: true, Effect: "colorloop"}); err != nil {
+		return fmt.Errorf("could not activate colorloop for %s: %w", name, err)
+	}
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer, l.Until = nil, nil
+	}
+	if d > 0 {
+		until := time.Now().Add(d)
+		l.Until = &until
+		l.timer = time.AfterFunc(d, func() { lp.expire(name, until) })
+	}
+	lp.loops[name] = l
+	return nil
+}
+
+// expire stops a timed loop, unless it was stopped or restarted since.
+func (lp *looper) expire(name string, until time.Time) {
+	lp.mu.Lock()
+	l, ok := lp.loops[name]
+	current := ok && l.Until != nil && l.Until.Equal(until)
+	lp.mu.Unlock()
+	if !current {
+		return
+	}
+	log.Printf("INFO: colorloop for %s is over", name)
+	if err := lp.stop(name); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// stop ends the colorloop of a light. A loop started through the API
+// restores the light, one started from elsewhere just stops looping.
+func (lp *looper) stop(name string) error {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	log.Printf("INFO: stopping colorloop for : %s", name)
+	ctx := context.Background()
+	light, err := lp.bridge.Light(ctx, name)
+	if err != nil {
+		return err
+	}
+	l, tracked := lp.loops[name]
+	switch {
+	case tracked:
+		err = restore(ctx, lp.bridge, name, l.previous)
+	case light.State.On && light.State.Effect == "colorloop":
+		err = lp.bridge.SetState(ctx, name, hue.LightState{On: true, Effect: "none"})
+	}
+	if err != nil {
+		return fmt.Errorf("could not stop colorloop for %s: %w", name, err)
+	}
+	if tracked {
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		delete(lp.loops, name)
+	}
+	return nil
+}
+
+// forget drops the loop of a light without stopping it, for when something
+// else takes the light over. It returns the state of the light from before
+// the loop, nil when none was tracked.
+func (lp *looper) forget(name string) *hue.LightState {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	l, ok := lp.loops[name]
+	if !ok {
+		return nil
+	}
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	delete(lp.loops, name)
+	return &l.previous
+}
+
+func (lp *looper) status(name string) (loopStatus, error) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+
+	light, err := lp.bridge.Light(context.Background(), name)
+	if err != nil {
+		return loopStatus{}, err
+	}
+	st := loopStatus{
+		Light:   name,
+		On:      light.State.On,
+		Looping: light.State.On && light.State.Effect == "colorloop",
+	}
+	if l, ok := lp.loops[name]; ok {
+		c := *l
+		st.Loop = &c
+	}
+	return st, nil
+}
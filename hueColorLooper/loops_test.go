@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/ezebunandu/gohome/huebridge"
+	hue "github.com/ezebunandu/gohue"
+)
+
+// fakeBridge serves the parts of the hue bridge API the colorlooper uses.
+type fakeBridge struct {
+	mu     sync.Mutex
+	lights map[string]*hue.Light
+}
+
+func newFakeBridge(t *testing.T, lights ...hue.Light) (*fakeBridge, string) {
+	t.Helper()
+	b := &fakeBridge{lights: make(map[string]*hue.Light)}
+	for i := range lights {
+		b.lights[strconv.Itoa(i+1)] = &lights[i]
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/{user}/lights", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		json.NewEncoder(w).Encode(b.lights)
+	})
+	mux.HandleFunc("GET /api/{user}/lights/{index}", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		json.NewEncoder(w).Encode(b.lights[r.PathValue("index")])
+	})
+	mux.HandleFunc("PUT /api/{user}/lights/{index}/state", func(w http.ResponseWriter, r *http.Request) {
+		var state struct {
+			hue.LightState
+			// a number, unlike in hue.LightState
+			TransitionTime uint16 `json:"transitiontime"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			t.Errorf("decoding light state: %v", err)
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		l := b.lights[r.PathValue("index")]
+		if !state.On {
+			// the real bridge only changes other attributes while on
+			state.LightState = hue.LightState{}
+		}
+		l.State.On = state.On
+		if state.Effect != "" {
+			l.State.Effect = state.Effect
+		}
+		if state.Bri != 0 {
+			l.State.Bri = state.Bri
+		}
+		switch {
+		case state.XY != nil:
+			l.State.XY, l.State.ColorMode = *state.XY, "xy"
+		case state.CT != 0:
+			l.State.CT, l.State.ColorMode = int(state.CT), "ct"
+		case state.Hue != 0 || state.Sat != 0:
+			l.State.Hue, l.State.Saturation, l.State.ColorMode = state.Hue, state.Sat, "hs"
+		case state.Effect == "colorloop":
+			l.State.ColorMode = "hs"
+		}
+		w.Write([]byte(`[{"success":{}}]`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return b, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func (b *fakeBridge) light(name string) hue.Light {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.lights {
+		if l.Name == name {
+			return *l
+		}
+	}
+	return hue.Light{}
+}
+
+func testLight(name string, on bool, bri uint8, xy [2]float32) hue.Light {
+	var l hue.Light
+	l.Name = name
+	l.State.On = on
+	l.State.Bri = bri
+	l.State.XY = xy
+	l.State.ColorMode = "xy"
+	l.State.Effect = "none"
+	return l
+}
+
+func newTestLooper(t *testing.T) (*fakeBridge, *config, *looper) {
+	t.Helper()
+	bridge, addr := newFakeBridge(t,
+		testLight("Lamp Stand 1", true, 120, [2]float32{0.4, 0.4}),
+		testLight("Lamp Stand 2", false, 200, [2]float32{0.2, 0.1}),
+	)
+	cfg := &config{
+		HueIPAddress: addr,
+		HueID:        "user",
+		Lights:       []light{{Name: "Lamp Stand 1"}, {Name: "Lamp Stand 2"}},
+		Groups:       map[string][]string{"lamps": {"lamp_stand_1", "lamp_stand_2"}},
+		Effects: map[string]*effect{
+			"party": {Type: effectCycle, Colors: []string{"red", "blue"}, Fade: 10 * time.Millisecond},
+			"dawn":  {Type: effectSunrise, Duration: 2 * time.Second},
+		},
+		CommandsPerSecond: 1000,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	return bridge, cfg, newLooper(huebridge.New(addr, cfg.HueID, huebridge.WithRateLimit(cfg.CommandsPerSecond)))
+}
+
+func TestLooper__TimedLoopRestoresPreviousState(t *testing.T) {
+	bridge, _, lp := newTestLooper(t)
+
+	for _, name := range []string{"Lamp Stand 1", "Lamp Stand 2"} {
+		if err := lp.start(name, 50*time.Millisecond, nil); err != nil {
+			t.Fatal(err)
+		}
+		if l := bridge.light(name); !l.State.On || l.State.Effect != "colorloop" {
+			t.Fatalf("%s is not colorlooping: %+v", name, l.State)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		lp.mu.Lock()
+		active := len(lp.loops)
+		lp.mu.Unlock()
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed loop never ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l1 := bridge.light("Lamp Stand 1")
+	if !l1.State.On || l1.State.Effect != "none" || l1.State.Bri != 120 || l1.State.XY != [2]float32{0.4, 0.4} {
+		t.Errorf("Lamp Stand 1 was not restored: %+v", l1.State)
+	}
+	l2 := bridge.light("Lamp Stand 2")
+	if l2.State.On || l2.State.Effect != "none" || l2.State.Bri != 200 || l2.State.XY != [2]float32{0.2, 0.1} {
+		t.Errorf("Lamp Stand 2 was not restored: %+v", l2.State)
+	}
+}
+
+func TestLooper__RestartKeepsStateFromFirstStart(t *testing.T) {
+	bridge, _, lp := newTestLooper(t)
+
+	if err := lp.start("Lamp Stand 1", time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := lp.start("Lamp Stand 1", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	st, err := lp.status("Lamp Stand 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Looping || st.Loop == nil || st.Loop.Until != nil {
+		t.Fatalf("status = %+v, want an untimed loop", st)
+	}
+
+	if err := lp.stop("Lamp Stand 1"); err != nil {
+		t.Fatal(err)
+	}
+	if l := bridge.light("Lamp Stand 1"); l.State.Effect != "none" || l.State.Bri != 120 {
+		t.Errorf("Lamp Stand 1 was not restored: %+v", l.State)
+	}
+}
+
+func TestMux__StartStatusAndStopJobs(t *testing.T) {
+	bridge, cfg, lp := newTestLooper(t)
+	jobs := newJobQueue(1, time.Millisecond)
+	mux := newMux(cfg, lp, newEffects(cfg, lp.bridge), jobs, nil)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	if w := serve("POST", "/colorloop/lamps?duration=soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid duration: got %d, want 400", w.Code)
+	}
+	if w := serve("GET", "/colorloop/nope"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown light: got %d, want 400", w.Code)
+	}
+	w := serve("POST", "/colorloop/lamps?duration=15m")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("start: got %d: %s", w.Code, w.Body)
+	}
+	var j job
+	if err := json.NewDecoder(w.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if loc := w.Header().Get("Location"); loc != "/jobs/"+j.ID {
+		t.Errorf("Location = %q, want /jobs/%s", loc, j.ID)
+	}
+	waitJob(t, jobs, j.ID)
+
+	w = serve("GET", "/jobs/"+j.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("job: got %d: %s", w.Code, w.Body)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != jobSucceeded || len(j.Lights) != 2 {
+		t.Errorf("job = %+v, want both lights started", j)
+	}
+	if w := serve("GET", "/jobs/nope"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: got %d, want 404", w.Code)
+	}
+
+	w = serve("GET", "/colorloop/lamps")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: got %d: %s", w.Code, w.Body)
+	}
+	var statuses []loopStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(statuses))
+	}
+	for _, st := range statuses {
+		if !st.Looping || st.Loop == nil || st.Loop.Until == nil {
+			t.Errorf("status = %+v, want a timed loop", st)
+		}
+	}
+
+	w = serve("DELETE", "/colorloop/lamp_stand_2")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("stop: got %d: %s", w.Code, w.Body)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if j = waitJob(t, jobs, j.ID); j.Status != jobSucceeded {
+		t.Errorf("stop job = %+v", j)
+	}
+	if l := bridge.light("Lamp Stand 2"); l.State.On || l.State.Effect != "none" {
+		t.Errorf("Lamp Stand 2 was not restored: %+v", l.State)
+	}
+	if l := bridge.light("Lamp Stand 1"); l.State.Effect != "colorloop" {
+		t.Errorf("Lamp Stand 1 stopped looping: %+v", l.State)
+	}
+}
+
+func TestMux__RequiresAuth(t *testing.T) {
+	_, cfg, lp := newTestLooper(t)
+	auth, err := httpauth.New([]httpauth.Key{
+		{Name: "dashboard", Secret: "dashboard-secret-123", Scopes: []string{httpauth.ScopeRead}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.SetAuditLog(io.Discard)
+	mux := newMux(cfg, lp, newEffects(cfg, lp.bridge), newJobQueue(1, time.Millisecond), auth)
+
+	tests := []struct {
+		method, target, token string
+		want                  int
+	}{
+		{"GET", "/colorloop/lamps", "", http.StatusUnauthorized},
+		{"GET", "/colorloop/lamps", "dashboard-secret-123", http.StatusOK},
+		{"GET", "/effects", "dashboard-secret-123", http.StatusOK},
+		{"POST", "/colorloop/lamps", "dashboard-secret-123", http.StatusForbidden},
+		{"DELETE", "/effects/lamps", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.target, w.Code, tt.want)
+		}
+	}
+}
@@ -5,11 +5,45 @@ import (
 	"os"
 	"time"
 
+	"github.com/ezebunandu/gohome/httpauth"
 	"gopkg.in/yaml.v3"
 )
 
 type light struct {
 	Name string
+	// timed changes of the light, turned off at night_start and on at
+	// night_end when it has none, from here or its groups
+	Schedule []event `yaml:"schedule"`
+}
+
+// group is a set of lights sharing a schedule.
+type group struct {
+	Name     string   `yaml:"name"`
+	Lights   []string `yaml:"lights"`
+	Schedule []event  `yaml:"schedule"`
+}
+
+// scene is a named light setting, for events to refer to.
+type scene struct {
+	// percent, 1 to 100
+	Brightness int `yaml:"brightness"`
+	// kelvin, 2000 to 6500
+	ColorTemp int `yaml:"color_temp"`
+}
+
+// event changes a light at a time of day, on every day or the given ones.
+// It turns the light off with state: off, or else on with the brightness
+// and color temperature given, or those of a scene.
+type event struct {
+	At         yamlHour `yaml:"at"`
+	Days       []string `yaml:"days"`
+	State      string   `yaml:"state"`
+	Brightness int      `yaml:"brightness"`
+	ColorTemp  int      `yaml:"color_temp"`
+	Scene      string   `yaml:"scene"`
+	// how long before At the light starts fading to the state, instantly
+	// when zero
+	Fade time.Duration `yaml:"fade"`
 }
 
 type yamlHour struct {
@@ -17,11 +51,20 @@ type yamlHour struct {
 }
 
 type config struct {
-	HueIPAddress string `yaml:"hue_ip_address"`
-	HueID        string `yaml:"hue_id"`
-	Lights []light `yaml:"lights"`
-	NightStart yamlHour `yaml:"night_start"`
-	NightEnd yamlHour `yaml:"night_end"`
+	HueIPAddress string           `yaml:"hue_ip_address"`
+	HueID        string           `yaml:"hue_id"`
+	Lights       []light          `yaml:"lights"`
+	Groups       []group          `yaml:"groups"`
+	Scenes       map[string]scene `yaml:"scenes"`
+	NightStart   yamlHour         `yaml:"night_start"`
+	NightEnd     yamlHour         `yaml:"night_end"`
+	// dim-down before night_start and sunrise before night_end, for the
+	// lights without a schedule
+	NightStartFade time.Duration   `yaml:"night_start_fade"`
+	NightEndFade   time.Duration   `yaml:"night_end_fade"`
+	Auth           httpauth.Config `yaml:"auth"`
+	// manual override of the schedule, kept across restarts
+	OverrideFile string `yaml:"override_file"`
 }
 
 func (yh *yamlHour) UnmarshalYAML(v *yaml.Node) error {
@@ -33,6 +76,20 @@ func (yh *yamlHour) UnmarshalYAML(v *yaml.Node) error {
 	return err
 }
 
+func (yh yamlHour) isSet() bool {
+	return !yh.t.IsZero()
+}
+
+// on returns the time yh stands for on the day of t, in t's location.
+func (yh yamlHour) on(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, yh.t.Hour(), yh.t.Minute(), 0, 0, t.Location())
+}
+
+func (yh yamlHour) String() string {
+	return yh.t.Format("3:04pm")
+}
+
 func newConfig(configFile string) (*config, error) {
 	cf, err := os.Open(configFile)
 	if err != nil {
@@ -50,7 +107,7 @@ func newConfig(configFile string) (*config, error) {
 		cfg.HueID = hueID
 	}
 
-	if cfg.NightStart.t.IsZero(){
+	if cfg.NightStart.t.IsZero() {
 		var err error
 		cfg.NightStart.t, err = time.Parse("3:04pm", "10:00pm") // night starts 10pm, if not defined in config file
 		if err != nil {
@@ -66,5 +123,9 @@ func newConfig(configFile string) (*config, error) {
 		}
 	}
 
+	if cfg.OverrideFile == "" {
+		cfg.OverrideFile = "override.json"
+	}
+
 	return &cfg, nil
 }
@@ -15,6 +15,12 @@ if [[ -z "${HUE_ID}" ]]; then
     exit 1
 fi
 
+# Check the API keys file is set, see ../httpauth
+if [[ -z "${API_KEYS_FILE}" || ! -f "${API_KEYS_FILE}" ]]; then
+    echo "Error: API_KEYS_FILE environment variable is not set to the API keys file"
+    exit 1
+fi
+
 # Create manifests/base directory if it doesn't exist
 mkdir -p manifests/base
 
@@ -29,6 +35,10 @@ export HUE_ID_BASE64=$(echo -n $HUE_ID | base64)
 echo "Applying secret and manifests..."
 sed -i -e "s#{HUE_ID}#${HUE_ID_BASE64}#g" manifests/secrets.yaml
 
+# Create base64 encoded API keys file
+export API_KEYS_BASE64=$(base64 -w0 < "${API_KEYS_FILE}")
+sed -i -e "s#\${API_KEYS}#${API_KEYS_BASE64}#g" manifests/secrets.yaml
+
 # Apply Kubernetes manifests using kustomize
 echo "Applying Kubernetes manifests..."
 kubectl apply -k manifests/
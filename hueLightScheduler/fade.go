@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	// the bridge takes transition times in deciseconds, up to 65535
+	maxTransition = 65535 * 100 * time.Millisecond
+
+	// a sunrise starts warm, and ends at about 4000K when the event sets
+	// no color temperature, in mireds
+	sunriseStartCT = 500
+	sunriseEndCT   = 250
+)
+
+// fadeState is a state sent with a transition time, which hue.LightState
+// has as a string the bridge rejects.
+type fadeState struct {
+	lightState
+	TransitionTime uint16 `json:"transitiontime"`
+}
+
+// fadeStep is a command of a fade: going to State over Transition, from At.
+type fadeStep struct {
+	At         time.Time
+	State      lightState
+	Transition time.Duration
+}
+
+// fading is a fade under way.
+type fading struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func interpolate(from, to lightState, frac float64) lightState {
+	s := to
+	s.Bri = uint8(math.Round(float64(from.Bri) + (float64(to.Bri)-float64(from.Bri))*frac))
+	if from.CT != 0 && to.CT != 0 {
+		s.CT = uint16(math.Round(float64(from.CT) + (float64(to.CT)-float64(from.CT))*frac))
+	}
+	return s
+}
+
+// fadeSteps splits a fade between start and end into transitions of the
+// light of at most chunk each, after going to from at once.
+func fadeSteps(from, to lightState, start, end time.Time, chunk time.Duration) []fadeStep {
+	total := end.Sub(start)
+	if total <= 0 {
+		return []fadeStep{{At: start, State: to}}
+	}
+	steps := []fadeStep{{At: start, State: from}}
+	for at := start; at.Before(end); {
+		next := at.Add(chunk)
+		if next.After(end) {
+			next = end
+		}
+		frac := float64(next.Sub(start)) / float64(total)
+		steps = append(steps, fadeStep{At: at, State: interpolate(from, to, frac), Transition: next.Sub(at)})
+		at = next
+	}
+	return steps
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// startFade fades a light to the state of a change in the background,
+// replacing any fade of the light under way.
+func (lm *lightManager) startFade(c change) {
+	lm.stopFade(c.Light)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fading{cancel: cancel, done: make(chan struct{})}
+	lm.fades[c.Light] = f
+	go func() {
+		defer close(f.done)
+		if err := lm.fade(ctx, c); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to fade light %s to %v: %v", c.Light, c.State, err)
+		}
+	}()
+}
+
+// stopFade cancels the fade of a light, if any, and waits for the light to
+// stop where it is, so a state sent after is not undone by the fade.
+func (lm *lightManager) stopFade(name string) {
+	f, ok := lm.fades[name]
+	if !ok {
+		return
+	}
+	delete(lm.fades, name)
+	f.cancel()
+	<-f.done
+}
+
+func (lm *lightManager) stopFades() {
+	for name := range lm.fades {
+		lm.stopFade(name)
+	}
+}
+
+// stopTransition stops a light where its transition has got to.
+func (lm *lightManager) stopTransition(name string, hasCT bool) {
+	log.Printf("INFO: fade of light %s cancelled", name)
+	stop := map[string]int{"bri_inc": 0}
+	if hasCT {
+		stop["ct_inc"] = 0
+	}
+	if err := lm.bridge.SetState(context.Background(), name, stop); err != nil {
+		log.Printf("Failed to stop light %s transition: %v", name, err)
+	}
+}
+
+// fade takes a light to the state of a change by the end of its fade. A
+// light turning on rises from dim and warm like a sunrise, one turning off
+// dims down before going off. The bridge transitions the light, in steps
+// when the fade is longer than it can take.
+func (lm *lightManager) fade(ctx context.Context, c change) error {
+	l, err := lm.bridge.Light(ctx, c.Light)
+	if err != nil {
+		return err
+	}
+	// lights without color temperature report no color mode
+	hasCT := l.State.ColorMode != ""
+	start, end := time.Now(), c.At.Add(c.fade)
+
+	var from, to lightState
+	if c.State.On {
+		to = c.State
+		switch {
+		case !hasCT:
+			to.CT = 0
+		case to.CT == 0:
+			to.CT = sunriseEndCT
+		}
+		from = lightState{On: true, Bri: 1}
+		if to.CT != 0 {
+			from.CT = sunriseStartCT
+		}
+		if l.State.On {
+			// already on, the fade starts where the light is
+			from.Bri = l.State.Bri
+			if to.CT != 0 && l.State.CT != 0 {
+				from.CT = uint16(l.State.CT)
+			}
+		}
+	} else {
+		if !l.State.On {
+			lm.setStateDone(c.Light, c.State)
+			return nil
+		}
+		from = lightState{On: true, Bri: l.State.Bri}
+		to = lightState{On: true, Bri: 1}
+	}
+
+	log.Printf("INFO: fading light %s to %v by %s", c.Light, c.State, end.Format("15:04"))
+	for _, step := range fadeSteps(from, to, start, end, maxTransition) {
+		if sleepUntil(ctx, step.At) != nil {
+			lm.stopTransition(c.Light, hasCT)
+			return ctx.Err()
+		}
+		s := fadeState{step.State, uint16(step.Transition.Round(100*time.Millisecond) / (100 * time.Millisecond))}
+		if err := lm.bridge.SetState(ctx, c.Light, s); err != nil {
+			if ctx.Err() != nil {
+				lm.stopTransition(c.Light, hasCT)
+			}
+			return err
+		}
+	}
+	if sleepUntil(ctx, end) != nil {
+		lm.stopTransition(c.Light, hasCT)
+		return ctx.Err()
+	}
+
+	if !c.State.On {
+		if err := lm.bridge.SetState(ctx, c.Light, c.State); err != nil {
+			return err
+		}
+		to = c.State
+	}
+	lm.setStateDone(c.Light, to)
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFadeSteps(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 10, 19, 5, 0, 0, 0, time.UTC)
+	from := lightState{On: true, Bri: 1, CT: 500}
+	to := lightState{On: true, Bri: 201, CT: 250}
+
+	steps := fadeSteps(from, to, start, start.Add(30*time.Minute), maxTransition)
+	want := []fadeStep{
+		{At: start, State: from},
+		{At: start, State: to, Transition: 30 * time.Minute},
+	}
+	if !slices.Equal(steps, want) {
+		t.Errorf("one transition: got %+v, want %+v", steps, want)
+	}
+
+	// longer than the bridge can take in one transition
+	steps = fadeSteps(from, to, start, start.Add(25*time.Minute), 10*time.Minute)
+	want = []fadeStep{
+		{At: start, State: from},
+		{At: start, State: lightState{On: true, Bri: 81, CT: 400}, Transition: 10 * time.Minute},
+		{At: start.Add(10 * time.Minute), State: lightState{On: true, Bri: 161, CT: 300}, Transition: 10 * time.Minute},
+		{At: start.Add(20 * time.Minute), State: to, Transition: 5 * time.Minute},
+	}
+	if !slices.Equal(steps, want) {
+		t.Errorf("steps: got %+v, want %+v", steps, want)
+	}
+
+	if steps := fadeSteps(from, to, start, start.Add(-time.Minute), maxTransition); len(steps) != 1 || steps[0].State != to {
+		t.Errorf("fade over: got %+v, want straight to the state", steps)
+	}
+}
+
+// transitions matches the transition times not 0, which depend on when
+// the fade got going.
+var transitions = regexp.MustCompile(`"transitiontime":[1-9][0-9]*`)
+
+func newTestManager(t *testing.T) (*fakeBridge, *lightManager) {
+	t.Helper()
+	cfg := testConfig(t)
+	bridge, client := newFakeBridge(t, "Lamp")
+	bridge.lights["1"].State.ColorMode = "ct"
+	lm, err := newLightManager(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bridge, lm
+}
+
+func TestLightManager__FadesOffAndOn(t *testing.T) {
+	t.Parallel()
+	bridge, lm := newTestManager(t)
+	bridge.lights["1"].State.On = true
+	bridge.lights["1"].State.Bri = 200
+
+	lm.startFade(change{Light: "Lamp", At: time.Now(), fade: 200 * time.Millisecond})
+	<-lm.fades["Lamp"].done
+	cmds := strings.Split(transitions.ReplaceAllString(strings.Join(bridge.commands(), "\n"), `"transitiontime":N`), "\n")
+	if want := []string{`{"on":true,"bri":200,"transitiontime":0}`, `{"on":true,"bri":1,"transitiontime":N}`, `{"on":false}`}; !slices.Equal(cmds, want) {
+		t.Errorf("dim-down: got %v, want %v", cmds, want)
+	}
+	if bridge.on("Lamp") {
+		t.Error("want the lamp off after the dim-down")
+	}
+
+	lm.startFade(change{Light: "Lamp", At: time.Now(), State: lightState{On: true, Bri: 127}, fade: 200 * time.Millisecond})
+	<-lm.fades["Lamp"].done
+	cmds = strings.Split(transitions.ReplaceAllString(strings.Join(bridge.commands()[3:], "\n"), `"transitiontime":N`), "\n")
+	if want := []string{`{"on":true,"bri":1,"ct":500,"transitiontime":0}`, `{"on":true,"bri":127,"ct":250,"transitiontime":N}`}; !slices.Equal(cmds, want) {
+		t.Errorf("sunrise: got %v, want %v", cmds, want)
+	}
+	if _, states := lm.snapshot(); states["Lamp"] != (lightState{On: true, Bri: 127, CT: 250}) {
+		t.Errorf("state set %+v, want the end of the sunrise", states["Lamp"])
+	}
+}
+
+func TestLightManager__OverrideCancelsFade(t *testing.T) {
+	t.Parallel()
+	bridge, lm := newTestManager(t)
+
+	lm.startFade(change{Light: "Lamp", At: time.Now(), State: onState(true), fade: time.Hour})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(bridge.commands()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fade not started: %v", bridge.commands())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cmd := bridge.commands()[1]
+	if tt, _ := strconv.Atoi(strings.TrimPrefix(transitions.FindString(cmd), `"transitiontime":`)); tt < 35990 || tt > 36000 {
+		t.Errorf("want an hour long transition, got %s", cmd)
+	}
+
+	go lm.run()
+	lm.powerChan <- &override{On: false, Until: time.Now().Add(time.Hour), By: "test"}
+	for bridge.on("Lamp") {
+		if time.Now().After(deadline) {
+			t.Fatal("override not applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cmds := bridge.commands()
+	if want := []string{`{"bri_inc":0,"ct_inc":0}`, `{"on":false}`}; !slices.Equal(cmds[len(cmds)-2:], want) {
+		t.Errorf("got %v, want the transition stopped then the override", cmds)
+	}
+}
+
+func TestLightManager__ExpiredOverrideKeepsFade(t *testing.T) {
+	t.Parallel()
+	bridge, lm := newTestManager(t)
+	// a /turnOff lasting until the next change, which is a sunrise
+	wake := time.Now()
+	lm.setOverride(&override{On: false, Until: wake, By: "test"})
+
+	lm.wakeUp(wake, []change{{Light: "Lamp", At: wake, State: lightState{On: true, Bri: 254}, fade: 200 * time.Millisecond}})
+	f, ok := lm.fades["Lamp"]
+	if !ok {
+		t.Fatalf("no fade started, commands %v", bridge.commands())
+	}
+	<-f.done
+	if lm.override != nil {
+		t.Errorf("override kept: %+v", lm.override)
+	}
+	cmds := strings.Split(transitions.ReplaceAllString(strings.Join(bridge.commands(), "\n"), `"transitiontime":N`), "\n")
+	if want := []string{`{"on":true,"bri":1,"ct":500,"transitiontime":0}`, `{"on":true,"bri":254,"ct":250,"transitiontime":N}`}; !slices.Equal(cmds, want) {
+		t.Errorf("got %v, want the sunrise", cmds)
+	}
+}
+
+func TestLightManager__OverrideEndingMidFadeResumesIt(t *testing.T) {
+	t.Parallel()
+	ends := map[string]func(lm *lightManager, now time.Time){
+		"expired": func(lm *lightManager, now time.Time) { lm.wakeUp(now, nil) },
+		"deleted": func(lm *lightManager, now time.Time) {
+			lm.setOverride(nil)
+			lm.setAll(now)
+		},
+	}
+	for name, end := range ends {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			// halfway through an hour long sunrise, after a /turnOff
+			now := time.Now()
+			cfg := testConfig(t)
+			var err error
+			if cfg.NightStart.t, err = time.Parse("15:04", now.Add(-2*time.Hour).Format("15:04")); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.NightEnd.t, err = time.Parse("15:04", now.Add(30*time.Minute).Format("15:04")); err != nil {
+				t.Fatal(err)
+			}
+			cfg.NightEndFade = time.Hour
+			bridge, client := newFakeBridge(t, "Lamp")
+			bridge.lights["1"].State.ColorMode = "ct"
+			lm, err := newLightManager(cfg, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lm.setOverride(&override{On: false, Until: now, By: "test"})
+
+			end(lm, now)
+			f, ok := lm.fades["Lamp"]
+			if !ok {
+				t.Fatalf("no fade resumed, commands %v", bridge.commands())
+			}
+			defer lm.stopFades()
+			deadline := time.Now().Add(2 * time.Second)
+			for len(bridge.commands()) < 2 {
+				if time.Now().After(deadline) {
+					t.Fatalf("fade not started: %v", bridge.commands())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			select {
+			case <-f.done:
+				t.Fatalf("fade done already, commands %v", bridge.commands())
+			default:
+			}
+			cmds := bridge.commands()
+			if cmds[0] != `{"on":true,"bri":1,"ct":500,"transitiontime":0}` {
+				t.Errorf("got %v, want the sunrise to start dim", cmds)
+			}
+			if tt, _ := strconv.Atoi(strings.TrimPrefix(transitions.FindString(cmds[1]), `"transitiontime":`)); tt < 17000 || tt > 18600 {
+				t.Errorf("want the sunrise to carry on for about half an hour, got %s", cmds[1])
+			}
+		})
+	}
+}
+
+func TestLightManager__TurnOnAfterDimDown(t *testing.T) {
+	t.Parallel()
+	bridge, lm := newTestManager(t)
+	bridge.lights["1"].State.On = true
+	bridge.lights["1"].State.Bri = 200
+
+	lm.startFade(change{Light: "Lamp", At: time.Now(), fade: 100 * time.Millisecond})
+	<-lm.fades["Lamp"].done
+	if bridge.on("Lamp") {
+		t.Fatal("want the lamp off after the dim-down")
+	}
+
+	// the service restarts with the lamp turned off, then a /turnOn
+	if err := saveOverride(lm.cfg.OverrideFile, &override{On: false, Until: time.Now().Add(time.Hour), By: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	mux, err := newMux(lm.cfg, lm.bridge, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/turnOn", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /turnOn: got %d", w.Code)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !bridge.on("Lamp") {
+		if time.Now().After(deadline) {
+			t.Fatal("lamp not turned on")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	if bri := bridge.lights["1"].State.Bri; bri != fullBri {
+		t.Errorf("lamp turned on at brightness %d, want %d", bri, fullBri)
+	}
+}
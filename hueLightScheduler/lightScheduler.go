@@ -1,169 +1,273 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/ezebunandu/gohome/huebridge"
 	hue "github.com/ezebunandu/gohue"
 )
 
 type lightManager struct {
-	cfg       *config
-	isOn      bool
-	bridge    *hue.Bridge
-	lights    map[string]*hue.Light // Map of light names to light objects
-	powerChan chan bool             // true for on, false for off
+	cfg    *config
+	sched  *schedule
+	bridge *huebridge.Client
+	// manual overrides, nil to go back to the schedule
+	powerChan chan *override
+	// by light
+	fades map[string]*fading
+
+	// only changed by run, and read by the status requests
+	mu sync.Mutex
+	// the state last set on each light
+	states   map[string]lightState
+	override *override
 }
 
-func newLightManager(cfg *config) (*lightManager, error) {
-	bridge, err := hue.NewBridge(cfg.HueIPAddress)
+func newLightManager(cfg *config, bridge *huebridge.Client) (*lightManager, error) {
+	sched, err := newSchedule(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create bridge: %w", err)
+		return nil, fmt.Errorf("invalid schedule: %w", err)
 	}
 
-	if err := bridge.Login(cfg.HueID); err != nil {
-		return nil, fmt.Errorf("failed to login to bridge: %w", err)
-	}
-
-	// Initialize lights map
-	lights := make(map[string]*hue.Light)
+	// Check the lights exist
 	for _, lightConfig := range cfg.Lights {
-		light, err := bridge.GetLightByName(lightConfig.Name)
-		if err != nil {
+		if _, err := bridge.Light(context.Background(), lightConfig.Name); err != nil {
 			return nil, fmt.Errorf("failed to get light %s: %w", lightConfig.Name, err)
 		}
-		lights[lightConfig.Name] = &light
+	}
+
+	o, err := loadOverride(cfg.OverrideFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load override: %w", err)
+	}
+	if o != nil {
+		log.Printf("INFO: keeping lights %s until %s, as overridden by %s", onOff(o.On), o.Until.Format(time.RFC1123), o.By)
 	}
 
 	return &lightManager{
 		cfg:       cfg,
+		sched:     sched,
 		bridge:    bridge,
-		lights:    lights,
-		powerChan: make(chan bool, 2),
+		states:    make(map[string]lightState),
+		fades:     make(map[string]*fading),
+		powerChan: make(chan *override, 2),
+		override:  o,
 	}, nil
 }
 
-func (lm *lightManager) setState(on bool) {
-	for name, light := range lm.lights {
-		var err error
-		if on {
-			err = light.On()
-		} else {
-			err = light.Off()
-		}
-
-		if err != nil {
-			log.Printf("Failed to set light %s state to %v: %v", name, on, err)
-			continue
-		}
+func onOff(on bool) string {
+	if on {
+		return "on"
 	}
-
-	lm.isOn = on
+	return "off"
 }
 
-func (lm *lightManager) scheduleStateChanges() {
-	for {
-		now := time.Now()
-		nextChange := lm.calculateNextChangeTime(now)
-		wait := nextChange.Sub(now)
-
-		log.Printf("Next state change scheduled for %v", nextChange.Format("15:04"))
+func (lm *lightManager) setState(name string, state lightState) {
+	if err := lm.bridge.SetState(context.Background(), name, state); err != nil {
+		log.Printf("Failed to set light %s state to %v: %v", name, state, err)
+		return
+	}
+	lm.setStateDone(name, state)
+}
 
-		time.Sleep(wait)
+func (lm *lightManager) setStateDone(name string, state lightState) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.states[name] = state
+}
 
-		shouldBeOn := !isNight(lm.cfg.NightStart.t, lm.cfg.NightEnd.t)
-		lm.setState(shouldBeOn)
+// setAll puts every light in the state it should be in at t, carrying on
+// with the fades under way.
+func (lm *lightManager) setAll(t time.Time) {
+	for _, name := range lm.sched.lights {
+		if !lm.resumeFade(name, t) {
+			lm.setState(name, lm.desiredState(name, t))
+		}
 	}
 }
 
-func (lm *lightManager) calculateNextChangeTime(now time.Time) time.Time {
-	today := now.Truncate(24 * time.Hour)
-
-	start := time.Date(today.Year(), today.Month(), today.Day(),
-		lm.cfg.NightStart.t.Hour(), lm.cfg.NightStart.t.Minute(), 0, 0, today.Location())
-	end := time.Date(today.Year(), today.Month(), today.Day(),
-		lm.cfg.NightEnd.t.Hour(), lm.cfg.NightEnd.t.Minute(), 0, 0, today.Location())
+// resumeFade picks up the fade of a light when it is under way at t and not
+// overridden, rather than jumping to its end, and reports whether it did.
+func (lm *lightManager) resumeFade(name string, t time.Time) bool {
+	c, ok := lm.sched.last(name, t)
+	if !ok || lm.override.active(t) || !c.At.Add(c.fade).After(t) {
+		return false
+	}
+	lm.startFade(c)
+	return true
+}
 
-	if end.Before(start) {
-		end = end.Add(24 * time.Hour)
+// matches tells whether a light is already in a state, so it need not be
+// sent again.
+func matches(l hue.Light, state lightState) bool {
+	if l.State.On != state.On {
+		return false
 	}
+	return !state.On ||
+		(state.Bri == 0 || l.State.Bri == state.Bri) && (state.CT == 0 || l.State.CT == int(state.CT))
+}
 
-	if now.Before(start) {
-		return start
-	} else if now.Before(end) {
-		return end
-	} else {
-		// Next day's start time
-		return start.Add(24 * time.Hour)
+// syncState reads the state of a light from the bridge and only changes it
+// when it is not already as it should be, so it does not flash.
+func (lm *lightManager) syncState(name string, state lightState) {
+	l, err := lm.bridge.Light(context.Background(), name)
+	if err != nil {
+		log.Printf("Failed to get light %s state: %v", name, err)
+		return
+	}
+	if matches(l, state) {
+		lm.setStateDone(name, state)
+		return
 	}
+	log.Printf("INFO: setting light %s %v", name, state)
+	lm.setState(name, state)
 }
 
-func isNight(start, end time.Time) bool {
-	now := time.Now()
+// setOverride replaces the override, saving it for the next start.
+func (lm *lightManager) setOverride(o *override) {
+	lm.mu.Lock()
+	lm.override = o
+	lm.mu.Unlock()
+	if err := saveOverride(lm.cfg.OverrideFile, o); err != nil {
+		log.Println("ERROR: saving override:", err)
+	}
+}
 
-	// Create today's date with the start and end times
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
-	todayEnd := time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, now.Location())
-
-	// Handle overnight periods (when end time is before start time)
-	if end.Before(start) {
-		if now.After(todayStart) {
-			// We're after start time today, so end time should be tomorrow
-			todayEnd = todayEnd.Add(24 * time.Hour)
-		} else {
-			// We're before end time today, so start time should be from yesterday
-			todayStart = todayStart.Add(-24 * time.Hour)
-		}
+// expireOverride drops the override once it has expired at t, and reports
+// whether it did.
+func (lm *lightManager) expireOverride(t time.Time) bool {
+	if lm.override == nil || lm.override.active(t) {
+		return false
 	}
+	log.Printf("INFO: override by %s expired, back to the schedule", lm.override.By)
+	lm.setOverride(nil)
+	return true
+}
 
-	return now.After(todayStart) && now.Before(todayEnd)
+// desiredState returns the state a light should be in at t: as overridden,
+// or else as scheduled.
+func (lm *lightManager) desiredState(name string, t time.Time) lightState {
+	return lm.sched.desired(lm.override, name, t)
+}
+
+// calculateNextChangeTime returns when the next scheduled change, of any
+// light, is.
+func (lm *lightManager) calculateNextChangeTime(now time.Time) time.Time {
+	next, _ := lm.sched.next(now)
+	return next
 }
 
 func (lm *lightManager) run() {
-	// First turn all lights off to ensure known state
-	lm.setState(false)
-	lm.isOn = false
+	// Start from the real state of the lights, a restart must neither flash
+	// them nor undo an override
+	now := time.Now()
+	for _, name := range lm.sched.lights {
+		// picking up a fade cut short
+		if lm.resumeFade(name, now) {
+			continue
+		}
+		lm.syncState(name, lm.desiredState(name, now))
+	}
 
-	// Calculate initial state
-	shouldBeOn := !isNight(lm.cfg.NightStart.t, lm.cfg.NightEnd.t)
-	lm.setState(shouldBeOn)
+	for {
+		now := time.Now()
+		wake, changes := lm.sched.next(now)
+		if lm.override.active(now) && lm.override.Until.Before(wake) {
+			wake, changes = lm.override.Until, nil
+		}
+		log.Printf("Next state change scheduled for %v", wake.Format("Mon 15:04"))
+		timer := time.NewTimer(wake.Sub(now))
+
+		select {
+		case o := <-lm.powerChan:
+			// Handle manual override requests, cancelling the fades
+			timer.Stop()
+			lm.stopFades()
+			lm.setOverride(o)
+			lm.setAll(time.Now())
+		case <-timer.C:
+			lm.wakeUp(wake, changes)
+		}
+	}
+}
 
-	// Start scheduling goroutine
-	go lm.scheduleStateChanges()
+// wakeUp makes the scheduled changes due at wake, unless an override holds
+// them off. When an override has just expired, the lights without a change
+// go back to the state the schedule has them in, or carry on fading to it.
+func (lm *lightManager) wakeUp(wake time.Time, changes []change) {
+	expired := lm.expireOverride(wake)
+	if lm.override.active(wake) {
+		log.Printf("INFO: lights overridden by %s until %s, skipping scheduled changes", lm.override.By, lm.override.Until.Format(time.RFC1123))
+		return
+	}
 
-	// Handle manual override requests
-	for newState := range lm.powerChan {
-		lm.setState(newState)
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		changed[c.Light] = true
+		if c.fade > 0 {
+			lm.startFade(c)
+			continue
+		}
+		lm.stopFade(c.Light)
+		log.Printf("INFO: setting light %s %v", c.Light, c.State)
+		lm.setState(c.Light, c.State)
+	}
+	if !expired {
+		return
+	}
+	for _, name := range lm.sched.lights {
+		if !changed[name] && !lm.resumeFade(name, wake) {
+			lm.setState(name, lm.desiredState(name, wake))
+		}
 	}
 }
 
-func newMux(cfg *config) (http.Handler, error) {
+func newMux(cfg *config, bridge *huebridge.Client, auth *httpauth.Authenticator) (http.Handler, error) {
 	mux := http.NewServeMux()
 
-	lm, err := newLightManager(cfg)
+	lm, err := newLightManager(cfg, bridge)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create light manager: %w", err)
 	}
 
 	go lm.run()
 
-	mux.HandleFunc("/turnOn", func(w http.ResponseWriter, _ *http.Request) {
-		log.Println("INFO: Received request to turn on light")
-		lm.powerChan <- true
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte("Turn on request accepted"))
-	})
+	// The lights stay as asked until the next scheduled change, or for
+	// ?for=2h
+	turn := func(on bool, accepted string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("INFO: Received request to turn %s light", onOff(on))
+			o, err := newOverride(r, on, lm.calculateNextChangeTime(time.Now()))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lm.powerChan <- o
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "%s until %s", accepted, o.Until.Format(time.RFC1123))
+		}
+	}
 
-	mux.HandleFunc("/turnOff", func(w http.ResponseWriter, _ *http.Request) {
-		log.Println("INFO: Received request to turn off light")
-		lm.powerChan <- false
+	mux.Handle("POST /turnOn", auth.RequireFunc(httpauth.ScopeControl, turn(true, "Turn on request accepted")))
+	mux.Handle("POST /turnOff", auth.RequireFunc(httpauth.ScopeControl, turn(false, "Turn off request accepted")))
+
+	mux.Handle("GET /status", auth.RequireFunc(httpauth.ScopeRead, statusHandler(lm)))
+	mux.Handle("GET /schedule", auth.RequireFunc(httpauth.ScopeRead, scheduleHandler(lm)))
+
+	mux.Handle("DELETE /override", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, _ *http.Request) {
+		log.Println("INFO: Received request to go back to the schedule")
+		lm.powerChan <- nil
 		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte("Turn off request accepted"))
-	})
+		w.Write([]byte("Schedule request accepted"))
+	}))
 
 	return mux, nil
 }
@@ -178,7 +282,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	handler, err := newMux(cfg)
+	auth, err := httpauth.Open(cfg.Auth)
+	if err != nil {
+		log.Println("ERROR:", err)
+		os.Exit(1)
+	}
+
+	handler, err := newMux(cfg, huebridge.New(cfg.HueIPAddress, cfg.HueID), auth)
 	if err != nil {
 		log.Println("ERROR:", err)
 		os.Exit(1)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ezebunandu/gohome/httpauth"
+)
+
+// longest override accepted, a forgotten override must not stop the
+// schedule for good
+const maxOverride = 7 * 24 * time.Hour
+
+// override is a manual change of the lights, which the schedule leaves
+// alone until it expires.
+type override struct {
+	On    bool      `json:"on"`
+	Until time.Time `json:"until"`
+	By    string    `json:"by"`
+}
+
+func (o *override) active(t time.Time) bool {
+	return o != nil && o.Until.After(t)
+}
+
+// loadOverride reads the override saved at path, nil when there is none or
+// it has expired.
+func loadOverride(path string) (*override, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var o *override
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, fmt.Errorf("reading override file: %w", err)
+	}
+	if !o.active(time.Now()) {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// saveOverride writes the override to a temporary file first, a crash
+// mid-write leaves the previous file in place. A nil override is saved as
+// null.
+func saveOverride(path string, o *override) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".override-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newOverride returns the override asked for by a request: until the next
+// scheduled change, or for ?for= when given.
+func newOverride(r *http.Request, on bool, nextChange time.Time) (*override, error) {
+	o := &override{On: on, Until: nextChange, By: httpauth.KeyName(r.Context())}
+	if o.By == "" {
+		o.By = r.RemoteAddr
+	}
+	if s := r.URL.Query().Get("for"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > maxOverride {
+			return nil, fmt.Errorf("invalid duration %q, must be positive and at most %s", s, maxOverride)
+		}
+		o.Until = time.Now().Add(d)
+	}
+	return o, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testConfig(t *testing.T) *config {
+	t.Helper()
+	cfg := &config{
+		Lights:       []light{{Name: "Lamp"}},
+		OverrideFile: filepath.Join(t.TempDir(), "override.json"),
+	}
+	var err error
+	if cfg.NightStart.t, err = time.Parse("3:04pm", "10:00pm"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.NightEnd.t, err = time.Parse("3:04pm", "5:30am"); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestOverride__SurvivesRestartUntilExpiry(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	if o, err := loadOverride(cfg.OverrideFile); err != nil || o != nil {
+		t.Fatalf("no file: got %+v, %v", o, err)
+	}
+
+	o := &override{On: true, Until: time.Now().Add(time.Hour), By: "dashboard"}
+	if err := saveOverride(cfg.OverrideFile, o); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadOverride(cfg.OverrideFile)
+	if err != nil || got == nil || !got.On || got.By != "dashboard" || !got.Until.Equal(o.Until) {
+		t.Fatalf("reloaded %+v, %v, want %+v", got, err, o)
+	}
+
+	o.Until = time.Now().Add(-time.Minute)
+	if err := saveOverride(cfg.OverrideFile, o); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := loadOverride(cfg.OverrideFile); err != nil || got != nil {
+		t.Errorf("expired: got %+v, %v, want none", got, err)
+	}
+
+	if err := saveOverride(cfg.OverrideFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := loadOverride(cfg.OverrideFile); err != nil || got != nil {
+		t.Errorf("cleared: got %+v, %v, want none", got, err)
+	}
+
+	os.WriteFile(cfg.OverrideFile, []byte("{"), 0o600)
+	if _, err := loadOverride(cfg.OverrideFile); err == nil {
+		t.Error("corrupt file: want an error")
+	}
+}
+
+func TestLightManager__OverrideHoldsOffSchedule(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	sched, err := newSchedule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm := &lightManager{cfg: cfg, sched: sched}
+	night := time.Date(2026, 10, 17, 23, 0, 0, 0, time.Local)
+
+	if lm.desiredState("Lamp", night).On {
+		t.Fatal("want lights off at 11pm without an override")
+	}
+
+	lm.setOverride(&override{On: true, Until: night.Add(2 * time.Hour), By: "dashboard"})
+	if !lm.desiredState("Lamp", night.Add(time.Hour)).On {
+		t.Error("want the override to keep the lights on")
+	}
+	if lm.expireOverride(night.Add(time.Hour)) {
+		t.Error("override expired early")
+	}
+
+	if !lm.expireOverride(night.Add(3*time.Hour)) || lm.desiredState("Lamp", night.Add(3*time.Hour)).On {
+		t.Error("want the schedule back once the override expired")
+	}
+	if lm.override != nil {
+		t.Errorf("expired override kept: %+v", lm.override)
+	}
+	if o, err := loadOverride(cfg.OverrideFile); err != nil || o != nil {
+		t.Errorf("expired override saved: %+v, %v", o, err)
+	}
+}
+
+func TestNewOverride(t *testing.T) {
+	t.Parallel()
+	next := time.Now().Add(5 * time.Hour)
+
+	r := httptest.NewRequest("POST", "/turnOn", nil)
+	o, err := newOverride(r, true, next)
+	if err != nil || !o.Until.Equal(next) || o.By != r.RemoteAddr {
+		t.Errorf("default: got %+v, %v, want until the next change", o, err)
+	}
+
+	o, err = newOverride(httptest.NewRequest("POST", "/turnOff?for=2h", nil), false, next)
+	if err != nil || o.On || time.Until(o.Until) > 2*time.Hour || time.Until(o.Until) < 119*time.Minute {
+		t.Errorf("for=2h: got %+v, %v", o, err)
+	}
+
+	for _, d := range []string{"soon", "-1h", "200h"} {
+		if _, err := newOverride(httptest.NewRequest("POST", "/turnOn?for="+d, nil), true, next); err == nil {
+			t.Errorf("for=%s: want an error", d)
+		}
+	}
+}
+
+func TestLightManager__StartsWithoutFlashing(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	bridge, client := newFakeBridge(t, "Lamp")
+	lm, err := newLightManager(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the lamp is already as scheduled
+	want := lm.desiredState("Lamp", time.Now())
+	bridge.lights["1"].State.On, bridge.lights["1"].State.Bri = want.On, want.Bri
+
+	go lm.run()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, states := lm.snapshot(); states["Lamp"] == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lamp state not read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cmds := bridge.commands(); len(cmds) != 0 {
+		t.Errorf("sent %v to a lamp already as scheduled", cmds)
+	}
+}
+
+func TestLightManager__KeepsSavedOverride(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	bridge, client := newFakeBridge(t, "Lamp")
+	sched, err := newSchedule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduled := sched.stateAt("Lamp", time.Now())
+	bridge.lights["1"].State.On, bridge.lights["1"].State.Bri = scheduled.On, scheduled.Bri
+
+	// overridden to the opposite of the schedule before a restart
+	o := &override{On: !scheduled.On, Until: time.Now().Add(time.Hour), By: "dashboard"}
+	if err := saveOverride(cfg.OverrideFile, o); err != nil {
+		t.Fatal(err)
+	}
+	lm, err := newLightManager(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lm.override == nil || lm.override.By != "dashboard" {
+		t.Fatalf("override not loaded: %+v", lm.override)
+	}
+
+	go lm.run()
+	deadline := time.Now().Add(2 * time.Second)
+	for bridge.on("Lamp") != o.On {
+		if time.Now().After(deadline) {
+			t.Fatalf("lamp left as scheduled, commands %v", bridge.commands())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, _ := lm.snapshot(); got == nil || got.On != o.On {
+		t.Errorf("override after start: %+v", got)
+	}
+	if saved, err := loadOverride(cfg.OverrideFile); err != nil || saved == nil || saved.On != o.On {
+		t.Errorf("saved override after start: %+v, %v", saved, err)
+	}
+}
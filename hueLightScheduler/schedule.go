@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// a light's state at any time is set by its last event, which is at most
+// a week ago
+const scheduleHorizon = 8 * 24 * time.Hour
+
+// longest fade, so it does not run into the next day's
+const maxFade = 12 * time.Hour
+
+// brightness of the lights turned on without one, a dim-down leaving them
+// at the lowest
+const fullBri = 254
+
+// onState returns the state of a light plainly turned on, or off.
+func onState(on bool) lightState {
+	if !on {
+		return lightState{}
+	}
+	return lightState{On: true, Bri: fullBri}
+}
+
+// lightState is a state sent to a light. The color temperature is left as
+// it is when zero.
+type lightState struct {
+	On  bool   `json:"on"`
+	Bri uint8  `json:"bri,omitempty"`
+	CT  uint16 `json:"ct,omitempty"`
+}
+
+func (s lightState) String() string {
+	if !s.On {
+		return "off"
+	}
+	var b strings.Builder
+	b.WriteString("on")
+	if s.Bri != 0 {
+		fmt.Fprintf(&b, ", brightness %d%%", int(math.Round(float64(s.Bri)*100/254)))
+	}
+	if s.CT != 0 {
+		fmt.Fprintf(&b, ", %dK", int(math.Round(1e6/float64(s.CT))))
+	}
+	return b.String()
+}
+
+// change is a scheduled change of a light.
+type change struct {
+	Light string     `json:"light"`
+	At    time.Time  `json:"at"`
+	State lightState `json:"state"`
+	// how long the light takes to get to the state, from At
+	Fade string `json:"fade,omitempty"`
+	// skipped for an override
+	Overridden bool `json:"overridden,omitempty"`
+
+	fade time.Duration
+}
+
+type scheduledEvent struct {
+	at yamlHour
+	// nil for every day
+	days  map[time.Weekday]bool
+	state lightState
+	fade  time.Duration
+}
+
+// schedule has the events of every light, in the order of the config.
+type schedule struct {
+	lights []string
+	events map[string][]scheduledEvent
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s = strings.ToLower(s); s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// stateOf returns the state an event puts a light in.
+func stateOf(e event, scenes map[string]scene) (lightState, error) {
+	switch strings.ToLower(e.State) {
+	case "off":
+		if e.Brightness != 0 || e.ColorTemp != 0 || e.Scene != "" {
+			return lightState{}, errors.New("an off event cannot set brightness, color_temp or scene")
+		}
+		return lightState{}, nil
+	case "", "on":
+	default:
+		return lightState{}, fmt.Errorf("invalid state %q, must be on or off", e.State)
+	}
+
+	sc := scene{Brightness: e.Brightness, ColorTemp: e.ColorTemp}
+	if e.Scene != "" {
+		if e.Brightness != 0 || e.ColorTemp != 0 {
+			return lightState{}, errors.New("an event with a scene cannot also set brightness or color_temp")
+		}
+		var ok bool
+		if sc, ok = scenes[e.Scene]; !ok {
+			return lightState{}, fmt.Errorf("unknown scene %q", e.Scene)
+		}
+	}
+	if e.State == "" && e.Scene == "" && sc.Brightness == 0 && sc.ColorTemp == 0 {
+		return lightState{}, errors.New("event has no state, brightness, color_temp or scene")
+	}
+	return sc.state()
+}
+
+func (sc scene) state() (lightState, error) {
+	s := onState(true)
+	if sc.Brightness != 0 {
+		if sc.Brightness < 1 || sc.Brightness > 100 {
+			return lightState{}, fmt.Errorf("invalid brightness %d, must be 1 to 100 percent", sc.Brightness)
+		}
+		s.Bri = uint8(max(1, math.Round(float64(sc.Brightness)*254/100)))
+	}
+	if sc.ColorTemp != 0 {
+		if sc.ColorTemp < 2000 || sc.ColorTemp > 6500 {
+			return lightState{}, fmt.Errorf("invalid color_temp %d, must be 2000 to 6500 kelvin", sc.ColorTemp)
+		}
+		// the bridge takes mireds, 153 to 500
+		s.CT = uint16(min(500, max(153, math.Round(1e6/float64(sc.ColorTemp)))))
+	}
+	return s, nil
+}
+
+func newScheduledEvent(e event, scenes map[string]scene) (scheduledEvent, error) {
+	if !e.At.isSet() {
+		return scheduledEvent{}, errors.New("event has no time")
+	}
+	if e.Fade < 0 || e.Fade > maxFade {
+		return scheduledEvent{}, fmt.Errorf("invalid fade %s, must be at most %s", e.Fade, maxFade)
+	}
+	se := scheduledEvent{at: e.At, fade: e.Fade}
+	if len(e.Days) > 0 {
+		se.days = make(map[time.Weekday]bool)
+		for _, name := range e.Days {
+			d, err := parseWeekday(name)
+			if err != nil {
+				return scheduledEvent{}, err
+			}
+			se.days[d] = true
+		}
+	}
+	var err error
+	se.state, err = stateOf(e, scenes)
+	return se, err
+}
+
+// newSchedule gathers the events of each light, its own and those of its
+// groups. Lights without any are turned off at night_start and on at
+// night_end.
+func newSchedule(cfg *config) (*schedule, error) {
+	for _, fade := range []time.Duration{cfg.NightStartFade, cfg.NightEndFade} {
+		if fade < 0 || fade > maxFade {
+			return nil, fmt.Errorf("invalid night fade %s, must be at most %s", fade, maxFade)
+		}
+	}
+	if len(cfg.Lights) == 0 {
+		return nil, errors.New("no lights to schedule")
+	}
+	s := &schedule{events: make(map[string][]scheduledEvent)}
+	add := func(light string, events []event) error {
+		for i, e := range events {
+			se, err := newScheduledEvent(e, cfg.Scenes)
+			if err != nil {
+				return fmt.Errorf("event %d: %w", i+1, err)
+			}
+			s.events[light] = append(s.events[light], se)
+		}
+		return nil
+	}
+
+	for _, l := range cfg.Lights {
+		if slices.Contains(s.lights, l.Name) {
+			return nil, fmt.Errorf("light %q is defined twice", l.Name)
+		}
+		s.lights = append(s.lights, l.Name)
+		if err := add(l.Name, l.Schedule); err != nil {
+			return nil, fmt.Errorf("light %q: %w", l.Name, err)
+		}
+	}
+	for _, g := range cfg.Groups {
+		for _, name := range g.Lights {
+			if !slices.Contains(s.lights, name) {
+				return nil, fmt.Errorf("group %q: unknown light %q", g.Name, name)
+			}
+			if err := add(name, g.Schedule); err != nil {
+				return nil, fmt.Errorf("group %q: %w", g.Name, err)
+			}
+		}
+	}
+	for _, name := range s.lights {
+		if len(s.events[name]) == 0 {
+			s.events[name] = []scheduledEvent{
+				{at: cfg.NightStart, state: lightState{}, fade: cfg.NightStartFade},
+				{at: cfg.NightEnd, state: onState(true), fade: cfg.NightEndFade},
+			}
+		}
+	}
+	return s, nil
+}
+
+// between returns the changes after from, up to and including to, in time
+// order, and for the same time in the order of the lights. Fading changes
+// are at the time they start.
+func (s *schedule) between(from, to time.Time) []change {
+	var changes []change
+	for _, light := range s.lights {
+		for _, e := range s.events[light] {
+			// a day either side, for days of different lengths
+			for day := from.AddDate(0, 0, -1); !day.After(to.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+				at := e.at.on(day)
+				if e.days != nil && !e.days[at.Weekday()] {
+					continue
+				}
+				c := change{Light: light, At: at.Add(-e.fade), State: e.state, fade: e.fade}
+				if e.fade > 0 {
+					c.Fade = e.fade.String()
+				}
+				if c.At.After(from) && !c.At.After(to) {
+					changes = append(changes, c)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b change) int {
+		return a.At.Compare(b.At)
+	})
+	return changes
+}
+
+// next returns when the next changes after t are, and what they are.
+func (s *schedule) next(t time.Time) (time.Time, []change) {
+	changes := s.between(t, t.Add(scheduleHorizon))
+	if len(changes) == 0 {
+		return time.Time{}, nil
+	}
+	at := changes[0].At
+	i := slices.IndexFunc(changes, func(c change) bool { return !c.At.Equal(at) })
+	if i < 0 {
+		i = len(changes)
+	}
+	return at, changes[:i]
+}
+
+// last returns the last change of a light up to t.
+func (s *schedule) last(light string, t time.Time) (change, bool) {
+	changes := s.between(t.Add(-scheduleHorizon), t)
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Light == light {
+			return changes[i], true
+		}
+	}
+	return change{}, false
+}
+
+// stateAt returns the state the schedule has a light in at t, set by the
+// light's last event, or fading to it.
+func (s *schedule) stateAt(light string, t time.Time) lightState {
+	c, _ := s.last(light, t)
+	return c.State
+}
+
+// desired returns the state a light should be in at t: as overridden, or
+// else as scheduled.
+func (s *schedule) desired(o *override, light string, t time.Time) lightState {
+	if o.active(t) {
+		return onState(o.On)
+	}
+	return s.stateAt(light, t)
+}
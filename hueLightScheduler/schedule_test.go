@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSchedule = `
+hue_ip_address: "127.0.0.1"
+night_start: "10:30pm"
+night_end: "5:30am"
+scenes:
+  reading: {brightness: 100, color_temp: 4000}
+lights:
+  - name: Lamp
+    schedule:
+      - at: "6:30am"
+        days: [mon, tue, wed, thu, fri]
+        brightness: 50
+        color_temp: 2700
+      - at: "8:00am"
+        days: [saturday, sunday]
+        state: on
+      - at: "7:00pm"
+        scene: reading
+      - at: "11:00pm"
+        state: off
+        fade: 15m
+  - name: Strip
+  - name: Shelf
+groups:
+  - name: shelves
+    lights: [Shelf]
+    schedule:
+      - at: "7:00pm"
+        state: on
+      - at: "11:00pm"
+        state: off
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestSchedule(t *testing.T) *schedule {
+	t.Helper()
+	cfg, err := newConfig(writeConfig(t, testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSchedule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSchedule__Next(t *testing.T) {
+	t.Parallel()
+	s := newTestSchedule(t)
+	loc := time.FixedZone("MST", -7*60*60)
+	// a Friday
+	friday := time.Date(2026, 10, 16, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		now  time.Time
+		at   time.Time
+		want []string
+	}{
+		{friday, friday.Add(5*time.Hour + 30*time.Minute), []string{"Strip on, brightness 100%"}},
+		{friday.Add(6 * time.Hour), friday.Add(6*time.Hour + 30*time.Minute), []string{"Lamp on, brightness 50%, 2703K"}},
+		{friday.Add(12 * time.Hour), friday.Add(19 * time.Hour), []string{"Lamp on, brightness 100%, 4000K", "Shelf on, brightness 100%"}},
+		// fades start early
+		{friday.Add(22*time.Hour + 30*time.Minute), friday.Add(22*time.Hour + 45*time.Minute), []string{"Lamp off in 15m0s"}},
+		{friday.Add(22*time.Hour + 50*time.Minute), friday.Add(23 * time.Hour), []string{"Shelf off"}},
+		// the weekday wake up is skipped on Saturday
+		{friday.Add(24 * time.Hour), friday.Add(29*time.Hour + 30*time.Minute), []string{"Strip on, brightness 100%"}},
+		{friday.Add(30 * time.Hour), friday.Add(32 * time.Hour), []string{"Lamp on, brightness 100%"}},
+	}
+	for _, tt := range tests {
+		at, changes := s.next(tt.now)
+		var got []string
+		for _, c := range changes {
+			g := c.Light + " " + c.State.String()
+			if c.Fade != "" {
+				g += " in " + c.Fade
+			}
+			got = append(got, g)
+		}
+		if !at.Equal(tt.at) || strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+			t.Errorf("next(%s) = %s %v, want %s %v", tt.now, at, got, tt.at, tt.want)
+		}
+	}
+}
+
+func TestSchedule__StateAt(t *testing.T) {
+	t.Parallel()
+	s := newTestSchedule(t)
+	loc := time.FixedZone("MST", -7*60*60)
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, loc)
+
+	tests := []struct {
+		light string
+		at    time.Time
+		want  string
+	}{
+		// from Sunday's last event
+		{"Lamp", monday.Add(6 * time.Hour), "off"},
+		{"Lamp", monday.Add(7 * time.Hour), "on, brightness 50%, 2703K"},
+		{"Lamp", monday.Add(20 * time.Hour), "on, brightness 100%, 4000K"},
+		{"Strip", monday.Add(4 * time.Hour), "off"},
+		{"Strip", monday.Add(12 * time.Hour), "on, brightness 100%"},
+		{"Shelf", monday.Add(12 * time.Hour), "off"},
+		{"Shelf", monday.Add(21 * time.Hour), "on, brightness 100%"},
+	}
+	for _, tt := range tests {
+		if got := s.stateAt(tt.light, tt.at).String(); got != tt.want {
+			t.Errorf("stateAt(%s, %s) = %s, want %s", tt.light, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestNewSchedule__Errors(t *testing.T) {
+	t.Parallel()
+	for name, lights := range map[string]string{
+		"no time":        `[{name: Lamp, schedule: [{state: on}]}]`,
+		"bad weekday":    `[{name: Lamp, schedule: [{at: "6:00am", days: [someday], state: on}]}]`,
+		"bad state":      `[{name: Lamp, schedule: [{at: "6:00am", state: dim}]}]`,
+		"off brightness": `[{name: Lamp, schedule: [{at: "6:00am", state: off, brightness: 10}]}]`,
+		"unknown scene":  `[{name: Lamp, schedule: [{at: "6:00am", scene: party}]}]`,
+		"scene and ct":   `[{name: Lamp, schedule: [{at: "6:00am", scene: reading, color_temp: 3000}]}]`,
+		"brightness":     `[{name: Lamp, schedule: [{at: "6:00am", brightness: 120}]}]`,
+		"color temp":     `[{name: Lamp, schedule: [{at: "6:00am", color_temp: 9000}]}]`,
+		"empty event":    `[{name: Lamp, schedule: [{at: "6:00am"}]}]`,
+		"long fade":      `[{name: Lamp, schedule: [{at: "6:00am", state: on, fade: 13h}]}]`,
+		"duplicate":      `[{name: Lamp}, {name: Lamp}]`,
+		"no lights":      `[]`,
+		"group member":   "[{name: Lamp}]\ngroups: [{name: g, lights: [Strip]}]",
+	} {
+		cfg, err := newConfig(writeConfig(t, "scenes: {reading: {brightness: 80}}\nlights: "+lights+"\n"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := newSchedule(cfg); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
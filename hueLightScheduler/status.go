@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// longest preview of the schedule
+const maxScheduleDays = 31
+
+// actualState is the state of a light as the bridge reports it.
+type actualState struct {
+	On        bool  `json:"on"`
+	Bri       uint8 `json:"bri"`
+	CT        int   `json:"ct"`
+	Reachable bool  `json:"reachable"`
+}
+
+type lightStatus struct {
+	Name    string     `json:"name"`
+	Desired lightState `json:"desired"`
+	// last sent to the light, none before the service set it
+	Set    *lightState  `json:"set,omitempty"`
+	Actual *actualState `json:"actual,omitempty"`
+	// whether the light is as desired
+	InSync bool   `json:"in_sync"`
+	Error  string `json:"error,omitempty"`
+}
+
+type status struct {
+	Time     time.Time     `json:"time"`
+	Override *override     `json:"override,omitempty"`
+	Lights   []lightStatus `json:"lights"`
+	// the next scheduled changes, all at the same time
+	Next []change `json:"next"`
+}
+
+// schedulePreview is what GET /schedule answers.
+type schedulePreview struct {
+	// the zone the times are in, the usual culprit when they look off
+	Zone    string    `json:"zone"`
+	Offset  string    `json:"offset"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Changes []change  `json:"changes"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// snapshot returns the override and the states last set, for requests to
+// read while run changes them.
+func (lm *lightManager) snapshot() (*override, map[string]lightState) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.override, maps.Clone(lm.states)
+}
+
+// markOverridden flags the changes an override skips.
+func markOverridden(changes []change, o *override) {
+	for i := range changes {
+		changes[i].Overridden = o.active(changes[i].At)
+	}
+}
+
+// statusHandler reports the desired and actual state of every light, as
+// queried from the bridge, with the override and the next changes.
+func statusHandler(lm *lightManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		o, states := lm.snapshot()
+		if !o.active(now) {
+			o = nil
+		}
+
+		st := status{Time: now, Override: o, Lights: []lightStatus{}}
+		for _, name := range lm.sched.lights {
+			ls := lightStatus{Name: name, Desired: lm.sched.desired(o, name, now)}
+			if s, ok := states[name]; ok {
+				ls.Set = &s
+			}
+			l, err := lm.bridge.Light(r.Context(), name)
+			if err != nil {
+				ls.Error = err.Error()
+			} else {
+				ls.Actual = &actualState{On: l.State.On, Bri: l.State.Bri, CT: l.State.CT, Reachable: l.State.Reachable}
+				ls.InSync = matches(l, ls.Desired)
+			}
+			st.Lights = append(st.Lights, ls)
+		}
+		_, st.Next = lm.sched.next(now)
+		markOverridden(st.Next, o)
+		writeJSON(w, st)
+	}
+}
+
+// scheduleHandler previews the scheduled changes of the next ?days=, 7 by
+// default, flagging those an override skips.
+func scheduleHandler(lm *lightManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := 7
+		if v := r.URL.Query().Get("days"); v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 1 || days > maxScheduleDays {
+				http.Error(w, fmt.Sprintf("invalid days %q, must be 1 to %d", v, maxScheduleDays), http.StatusBadRequest)
+				return
+			}
+		}
+
+		from := time.Now()
+		to := from.AddDate(0, 0, days)
+		o, _ := lm.snapshot()
+		changes := lm.sched.between(from, to)
+		if changes == nil {
+			changes = []change{}
+		}
+		markOverridden(changes, o)
+
+		zone, _ := from.Zone()
+		writeJSON(w, schedulePreview{
+			Zone:    zone,
+			Offset:  from.Format("-07:00"),
+			From:    from,
+			To:      to,
+			Changes: changes,
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ezebunandu/gohome/huebridge"
+	hue "github.com/ezebunandu/gohue"
+)
+
+// fakeBridge serves lights, keeping the states they are set to and the
+// commands sent.
+type fakeBridge struct {
+	mu     sync.Mutex
+	lights map[string]*hue.Light
+	puts   []string
+}
+
+func newFakeBridge(t *testing.T, names ...string) (*fakeBridge, *huebridge.Client) {
+	t.Helper()
+	b := &fakeBridge{lights: make(map[string]*hue.Light)}
+	for i, name := range names {
+		l := &hue.Light{Name: name}
+		l.State.Reachable = true
+		b.lights[string(rune('1'+i))] = l
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/user/lights", func(w http.ResponseWriter, _ *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		json.NewEncoder(w).Encode(b.lights)
+	})
+	mux.HandleFunc("GET /api/user/lights/{index}", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		json.NewEncoder(w).Encode(b.lights[r.PathValue("index")])
+	})
+	mux.HandleFunc("PUT /api/user/lights/{index}/state", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		b.puts = append(b.puts, string(body))
+		var state lightState
+		json.Unmarshal(body, &state)
+		l := b.lights[r.PathValue("index")]
+		if strings.Contains(string(body), "_inc") {
+			w.Write([]byte(`[{"success":{}}]`))
+			return
+		}
+		l.State.On = state.On
+		if state.Bri != 0 {
+			l.State.Bri = state.Bri
+		}
+		if state.CT != 0 {
+			l.State.CT = int(state.CT)
+		}
+		w.Write([]byte(`[{"success":{}}]`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return b, huebridge.New(strings.TrimPrefix(srv.URL, "http://"), "user", huebridge.WithRateLimit(1000))
+}
+
+func (b *fakeBridge) commands() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.puts...)
+}
+
+func (b *fakeBridge) on(name string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.lights {
+		if l.Name == name {
+			return l.State.On
+		}
+	}
+	return false
+}
+
+func TestMux__StatusAndSchedule(t *testing.T) {
+	t.Parallel()
+	cfg := testConfig(t)
+	cfg.Lights = []light{{Name: "Lamp"}, {Name: "Strip"}}
+	bridge, client := newFakeBridge(t, "Lamp", "Strip")
+	mux, err := newMux(cfg, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	get := func(path string, v any) int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	resp, err := http.Post(ts.URL+"/turnOn?for=1h", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for !bridge.on("Lamp") || !bridge.on("Strip") {
+		if time.Now().After(deadline) {
+			t.Fatal("lights not turned on")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var st status
+	for {
+		get("/status", &st)
+		if st.Override != nil && len(st.Lights) == 2 && st.Lights[1].Set != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %+v, want the override and both lights set", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, ls := range st.Lights {
+		if !ls.Desired.On || ls.Actual == nil || !ls.Actual.On || !ls.InSync {
+			t.Errorf("light %+v, want on and in sync", ls)
+		}
+	}
+	if len(st.Next) != 2 {
+		t.Errorf("next = %+v, want a change for each light", st.Next)
+	}
+
+	var preview schedulePreview
+	if code := get("/schedule?days=3", &preview); code != http.StatusOK {
+		t.Fatalf("GET /schedule: got %d", code)
+	}
+	// an on and an off a day for each light
+	if n := len(preview.Changes); n != 12 {
+		t.Errorf("got %d changes in 3 days, want 12", n)
+	}
+	if preview.Zone == "" || preview.Offset == "" {
+		t.Errorf("preview has no zone: %+v", preview)
+	}
+
+	for _, days := range []string{"0", "32", "week"} {
+		if code := get("/schedule?days="+days, nil); code != http.StatusBadRequest {
+			t.Errorf("days=%s: got %d, want 400", days, code)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package huebridge is a client for the lights of a hue bridge, shared by
+// the services driving them. A Client keeps its connections to the bridge
+// open, remembers where each light is and spaces out the commands it sends
+// to what the bridge can take.
+package huebridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	hue "github.com/ezebunandu/gohue"
+)
+
+const (
+	// the bridge drops light commands sent faster than about 10 a second
+	DefaultRateLimit = 10
+	// how long the light names are trusted before listing them again
+	DefaultLightTTL = 5 * time.Minute
+
+	requestTimeout = 5 * time.Second
+
+	// hue API error types
+	errTypeUnauthorized = 1
+	errTypeNotAvailable = 3
+)
+
+var (
+	// the bridge could not be reached, or its response not read
+	ErrUnreachable = errors.New("hue bridge unreachable")
+	// the bridge does not know the user, or hue id
+	ErrUnauthorized  = errors.New("hue bridge user unauthorized")
+	ErrLightNotFound = errors.New("light not found")
+)
+
+// BridgeError is an error the bridge answered a request with.
+type BridgeError struct {
+	Type        int    `json:"type"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+func (e *BridgeError) Error() string {
+	return fmt.Sprintf("hue bridge error %d on %s: %s", e.Type, e.Address, e.Description)
+}
+
+// Is makes errors.Is(err, ErrUnauthorized) hold for the bridge rejecting
+// the user.
+func (e *BridgeError) Is(target error) bool {
+	return target == ErrUnauthorized && e.Type == errTypeUnauthorized
+}
+
+// Client is a session with a hue bridge, safe for concurrent use.
+type Client struct {
+	address string
+	user    string
+	http    *http.Client
+	limit   *limiter
+	ttl     time.Duration
+
+	mu        sync.Mutex
+	lights    map[string]int
+	refreshed time.Time
+}
+
+type Option func(*Client)
+
+// WithRateLimit sets how many commands a second are sent to the lights.
+func WithRateLimit(perSecond int) Option {
+	return func(c *Client) {
+		if perSecond > 0 {
+			c.limit = newLimiter(perSecond)
+		}
+	}
+}
+
+// WithLightTTL sets how long the light names are trusted. A light that is
+// not found, or not where it was, lists them again anyway.
+func WithLightTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.ttl = ttl
+	}
+}
+
+// New returns a client for the bridge at address, an ip address or
+// host:port, as user. Nothing is sent to the bridge until it is used.
+func New(address, user string, opts ...Option) *Client {
+	c := &Client{
+		address: address,
+		user:    user,
+		http:    &http.Client{Timeout: requestTimeout},
+		limit:   newLimiter(DefaultRateLimit),
+		ttl:     DefaultLightTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body any, v any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+c.address+"/api/"+c.user+path, r)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s %s: %s", ErrUnreachable, method, path, resp.Status)
+	}
+
+	// Errors come as a list, even for requests answered with an object
+	var results []struct {
+		Error *BridgeError `json:"error"`
+	}
+	if json.Unmarshal(b, &results) == nil {
+		for _, res := range results {
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Lights returns every light of the bridge. The lights have no Bridge set,
+// they are changed through the client.
+func (c *Client) Lights(ctx context.Context) ([]hue.Light, error) {
+	byIndex := make(map[string]hue.Light)
+	if err := c.do(ctx, http.MethodGet, "/lights", nil, &byIndex); err != nil {
+		return nil, err
+	}
+	lights := make([]hue.Light, 0, len(byIndex))
+	names := make(map[string]int, len(byIndex))
+	for index, l := range byIndex {
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid light index %q", index)
+		}
+		l.Index = i
+		lights = append(lights, l)
+		names[l.Name] = i
+	}
+
+	c.mu.Lock()
+	c.lights, c.refreshed = names, time.Now()
+	c.mu.Unlock()
+	return lights, nil
+}
+
+// index returns where a light is, listing the lights when they have not
+// been for too long, the light is not known or refresh is set.
+func (c *Client) index(ctx context.Context, name string, refresh bool) (int, error) {
+	c.mu.Lock()
+	i, ok := c.lights[name]
+	stale := time.Since(c.refreshed) > c.ttl
+	c.mu.Unlock()
+	if ok && !stale && !refresh {
+		return i, nil
+	}
+
+	if _, err := c.Lights(ctx); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	i, ok = c.lights[name]
+	c.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrLightNotFound, name)
+	}
+	return i, nil
+}
+
+// Light returns the current state of a light.
+func (c *Client) Light(ctx context.Context, name string) (hue.Light, error) {
+	for refresh := false; ; refresh = true {
+		i, err := c.index(ctx, name, refresh)
+		if err != nil {
+			return hue.Light{}, err
+		}
+		var l hue.Light
+		err = c.do(ctx, http.MethodGet, "/lights/"+strconv.Itoa(i), nil, &l)
+		var be *BridgeError
+		switch {
+		case errors.As(err, &be) && be.Type == errTypeNotAvailable && !refresh:
+		case err != nil:
+			return hue.Light{}, err
+		case l.Name != name && !refresh:
+			// renamed, or deleted and its index reused
+		case l.Name != name:
+			return hue.Light{}, fmt.Errorf("%w: %s", ErrLightNotFound, name)
+		default:
+			l.Index = i
+			return l, nil
+		}
+	}
+}
+
+// SetState changes the state of a light, usually with a hue.LightState,
+// waiting for its turn under the rate limit.
+func (c *Client) SetState(ctx context.Context, name string, state any) error {
+	for refresh := false; ; refresh = true {
+		i, err := c.index(ctx, name, refresh)
+		if err != nil {
+			return err
+		}
+		if err := c.limit.wait(ctx); err != nil {
+			return err
+		}
+		err = c.do(ctx, http.MethodPut, "/lights/"+strconv.Itoa(i)+"/state", state, nil)
+		var be *BridgeError
+		if errors.As(err, &be) && be.Type == errTypeNotAvailable && !refresh {
+			continue
+		}
+		return err
+	}
+}
+
+// On turns a light on.
+func (c *Client) On(ctx context.Context, name string) error {
+	return c.SetState(ctx, name, hue.LightState{On: true})
+}
+
+// Off turns a light off.
+func (c *Client) Off(ctx context.Context, name string) error {
+	return c.SetState(ctx, name, hue.LightState{On: false})
+}
@@ -0,0 +1,200 @@
+package huebridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hue "github.com/ezebunandu/gohue"
+)
+
+// fakeBridge serves the lights of a user, counting the times they are
+// listed.
+type fakeBridge struct {
+	mu     sync.Mutex
+	lights map[string]*hue.Light
+	listed int
+	puts   []string
+}
+
+func newFakeBridge(t *testing.T, names ...string) (*fakeBridge, string) {
+	t.Helper()
+	b := &fakeBridge{lights: make(map[string]*hue.Light)}
+	for i, name := range names {
+		l := &hue.Light{Name: name}
+		b.lights[string(rune('1'+i))] = l
+	}
+
+	unauthorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.PathValue("user") == "user" {
+			return false
+		}
+		w.Write([]byte(`[{"error":{"type":1,"address":"/","description":"unauthorized user"}}]`))
+		return true
+	}
+	notAvailable := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"error":{"type":3,"address":"/lights/` + r.PathValue("index") + `","description":"resource, /lights/` + r.PathValue("index") + `, not available"}}]`))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/{user}/lights", func(w http.ResponseWriter, r *http.Request) {
+		if unauthorized(w, r) {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.listed++
+		json.NewEncoder(w).Encode(b.lights)
+	})
+	mux.HandleFunc("GET /api/{user}/lights/{index}", func(w http.ResponseWriter, r *http.Request) {
+		if unauthorized(w, r) {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		l, ok := b.lights[r.PathValue("index")]
+		if !ok {
+			notAvailable(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(l)
+	})
+	mux.HandleFunc("PUT /api/{user}/lights/{index}/state", func(w http.ResponseWriter, r *http.Request) {
+		if unauthorized(w, r) {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		l, ok := b.lights[r.PathValue("index")]
+		if !ok {
+			notAvailable(w, r)
+			return
+		}
+		var state hue.LightState
+		json.NewDecoder(r.Body).Decode(&state)
+		l.State.On = state.On
+		b.puts = append(b.puts, l.Name)
+		w.Write([]byte(`[{"success":{"/lights/` + r.PathValue("index") + `/state/on":true}}]`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return b, strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestClient__CachesLightIndexes(t *testing.T) {
+	t.Parallel()
+	bridge, addr := newFakeBridge(t, "Lamp", "Strip")
+	c := New(addr, "user", WithRateLimit(1000))
+	ctx := context.Background()
+
+	for range 3 {
+		if err := c.On(ctx, "Strip"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := c.Light(ctx, "Strip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.State.On || l.Index != 2 {
+		t.Errorf("light = %+v, want Strip on at index 2", l)
+	}
+	if bridge.listed != 1 {
+		t.Errorf("lights listed %d times, want once", bridge.listed)
+	}
+}
+
+func TestClient__RefreshesMovedLights(t *testing.T) {
+	t.Parallel()
+	bridge, addr := newFakeBridge(t, "Lamp", "Strip")
+	c := New(addr, "user", WithRateLimit(1000))
+	ctx := context.Background()
+
+	if _, err := c.Light(ctx, "Strip"); err != nil {
+		t.Fatal(err)
+	}
+	// The strip is re-paired and gets a new index
+	bridge.mu.Lock()
+	bridge.lights["3"] = bridge.lights["2"]
+	delete(bridge.lights, "2")
+	bridge.mu.Unlock()
+
+	if err := c.Off(ctx, "Strip"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := c.Light(ctx, "Strip"); err != nil || l.Index != 3 {
+		t.Errorf("Light = %+v, %v, want index 3", l, err)
+	}
+
+	// The lamp is renamed
+	bridge.mu.Lock()
+	bridge.lights["1"].Name = "Reading Lamp"
+	bridge.mu.Unlock()
+	if _, err := c.Light(ctx, "Lamp"); !errors.Is(err, ErrLightNotFound) {
+		t.Errorf("renamed light: got %v, want ErrLightNotFound", err)
+	}
+	if _, err := c.Light(ctx, "Reading Lamp"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient__TypedErrors(t *testing.T) {
+	t.Parallel()
+	_, addr := newFakeBridge(t, "Lamp")
+	ctx := context.Background()
+
+	err := New(addr, "intruder").On(ctx, "Lamp")
+	var be *BridgeError
+	if !errors.Is(err, ErrUnauthorized) || !errors.As(err, &be) || be.Type != 1 {
+		t.Errorf("unknown user: got %v, want ErrUnauthorized", err)
+	}
+
+	if err := New(addr, "user").On(ctx, "Garage"); !errors.Is(err, ErrLightNotFound) {
+		t.Errorf("unknown light: got %v, want ErrLightNotFound", err)
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	if _, err := New(strings.TrimPrefix(srv.URL, "http://"), "user").Lights(ctx); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("bridge down: got %v, want ErrUnreachable", err)
+	}
+}
+
+func TestClient__RateLimitsCommands(t *testing.T) {
+	t.Parallel()
+	bridge, addr := newFakeBridge(t, "Lamp", "Strip")
+	c := New(addr, "user", WithRateLimit(50))
+	ctx := context.Background()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, name := range []string{"Lamp", "Strip", "Lamp", "Strip", "Lamp"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.On(ctx, name); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 commands at 50/s took %s, want at least 80ms", elapsed)
+	}
+	if len(bridge.puts) != 5 {
+		t.Errorf("got %d commands, want 5", len(bridge.puts))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	c.limit.next = time.Now().Add(time.Hour)
+	if err := c.On(cancelled, "Lamp"); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: got %v", err)
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/ezebunandu/gohome/huebridge
+
+go 1.23.4
+
+require github.com/ezebunandu/gohue v0.0.0-20241219053637-5238c4a2e098
@@ -0,0 +1,44 @@
+package huebridge
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter spaces out commands evenly, each caller waiting for its turn.
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newLimiter(perSecond int) *limiter {
+	return &limiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next command may be sent, or ctx is done.
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -9,6 +9,7 @@ import (
     "strconv"
     "gopkg.in/yaml.v3"
 
+	"github.com/ezebunandu/gohome/httpauth"
 	hue "github.com/ezebunandu/gohue"
 )
 
@@ -42,6 +43,7 @@ type config struct {
     LightName string `yaml:"light_name"`
     MaxColor string `yaml:"max_color"`
     Colors []color `yaml:"colors"`
+    Auth httpauth.Config `yaml:"auth"`
 }
 
 func (cfg *config) sortColorRange() *config {
@@ -63,6 +65,7 @@ func (cfg *config) UnmarshalYAML(unmarshal func(interface{}) error) error {
         LightName string `yaml:"light_name"`
         MaxColor string `yaml:"max_color"`
         Colors []color `yaml:"colors"`
+        Auth httpauth.Config `yaml:"auth"`
     }
     if err := unmarshal(&raw); err != nil {
         return err
@@ -87,6 +90,7 @@ func (cfg *config) UnmarshalYAML(unmarshal func(interface{}) error) error {
     cfg.LightName = raw.LightName
     cfg.MaxColor = raw.MaxColor
     cfg.Colors = raw.Colors
+    cfg.Auth = raw.Auth
 
     return nil
 }
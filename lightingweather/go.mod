@@ -4,6 +4,8 @@ go 1.23.4
 
 require (
 	github.com/briandowns/openweathermap v0.21.0
+	github.com/ezebunandu/gohome/httpauth v0.0.0
+	github.com/ezebunandu/gohome/huebridge v0.0.0
 	github.com/ezebunandu/gohue v0.0.0-20241219053637-5238c4a2e098
 	github.com/prometheus/client_golang v1.20.5
 	gopkg.in/yaml.v3 v3.0.1
@@ -22,3 +24,10 @@ require (
 	golang.org/x/sys v0.22.0 // indirect
 	google.golang.org/protobuf v1.34.2 // indirect
 )
+
+// the hue bridge client and API authentication are shared with the other
+// hue services
+replace (
+	github.com/ezebunandu/gohome/httpauth => ../httpauth
+	github.com/ezebunandu/gohome/huebridge => ../huebridge
+)
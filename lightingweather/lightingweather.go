@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"flag"
 	"log"
@@ -14,6 +15,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	owm "github.com/briandowns/openweathermap"
+	"github.com/ezebunandu/gohome/httpauth"
+	"github.com/ezebunandu/gohome/huebridge"
 	hue "github.com/ezebunandu/gohue"
 )
 
@@ -34,29 +37,13 @@ func getCurrentTemperature(cfg *config) (int, error) {
 	return int(math.Round(w.Main.Temp)), err
 }
 
-func setLight(cfg *config, currentTemp int) error {
-	bridge, err := hue.NewBridge(cfg.HueIPAddress)
-	if err != nil {
-		return err
-	}
-
-	if err := bridge.Login(cfg.HueID); err != nil {
-		return err
-	}
-
-	weatherLight, err := bridge.GetLightByName(cfg.LightName)
-	if err != nil {
-		return err
-	}
-
-	if err := weatherLight.SetColor(
-		pickColor(cfg, currentTemp)); err != nil {
-		return err
-	}
-	return weatherLight.On()
+func setLight(cfg *config, bridge *huebridge.Client, currentTemp int) error {
+	return bridge.SetState(context.Background(), cfg.LightName, hue.LightState{
+		On: true,
+		XY: pickColor(cfg, currentTemp)})
 }
 
-func lightweather(cfg *config, chRefresh <-chan struct{}) {
+func lightweather(cfg *config, bridge *huebridge.Client, chRefresh <-chan struct{}) {
 	externalWeatherTemp := promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "external_weather_temperature",
 	})
@@ -71,7 +58,7 @@ func lightweather(cfg *config, chRefresh <-chan struct{}) {
 		externalWeatherTemp.Set(float64(currentTemp))
 
 		log.Println("INFO: Setting light")
-		if err := setLight(cfg, currentTemp); err != nil {
+		if err := setLight(cfg, bridge, currentTemp); err != nil {
 			log.Println("ERROR:", err)
 		}
 	}
@@ -86,28 +73,14 @@ func lightweather(cfg *config, chRefresh <-chan struct{}) {
 	}
 }
 
-func turnOffLight(cfg *config) error {
-	bridge, err := hue.NewBridge(cfg.HueIPAddress)
-	if err != nil {
-		return err
-	}
-
-	if err := bridge.Login(cfg.HueID); err != nil {
-		return err
-	}
-
-	weatherLight, err := bridge.GetLightByName(cfg.LightName)
-	if err != nil {
-		return err
-	}
-
-	return weatherLight.Off()
+func turnOffLight(cfg *config, bridge *huebridge.Client) error {
+	return bridge.Off(context.Background(), cfg.LightName)
 }
 
-func powerOffLight(cfg *config, chPowerOff <-chan struct{}) {
+func powerOffLight(cfg *config, bridge *huebridge.Client, chPowerOff <-chan struct{}) {
 	run := func() {
 		log.Println("INFO: Powering Off the Hue Light")
-		err := turnOffLight(cfg)
+		err := turnOffLight(cfg, bridge)
 		if err != nil {
 			log.Println("ERROR:", err)
 		}
@@ -123,7 +96,7 @@ func powerOffLight(cfg *config, chPowerOff <-chan struct{}) {
 	}
 }
 
-func newMux(cfg *config) http.Handler {
+func newMux(cfg *config, bridge *huebridge.Client, auth *httpauth.Authenticator) http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
@@ -134,24 +107,24 @@ func newMux(cfg *config) http.Handler {
 	chRefresh := make(chan struct{}, 2)
 	chPowerOff := make(chan struct{}, 2)
 
-	go lightweather(cfg, chRefresh)
-	go powerOffLight(cfg, chPowerOff)
+	go lightweather(cfg, bridge, chRefresh)
+	go powerOffLight(cfg, bridge, chPowerOff)
 
 	chRefresh <- struct{}{}
 
-	mux.HandleFunc("POST /refresh", func(w http.ResponseWriter, _ *http.Request) {
+	mux.Handle("POST /refresh", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, _ *http.Request) {
 		log.Println("INFO: Received refresh request")
 		chRefresh <- struct{}{}
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte("Refresh request accepted"))
-	})
+	}))
 
-	mux.HandleFunc("POST /powerHueOff", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("POST /powerHueOff", auth.RequireFunc(httpauth.ScopeControl, func(w http.ResponseWriter, r *http.Request) {
 		log.Println("INFO: Received Poweroff request")
 		chPowerOff <- struct{}{}
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte("Poweroff request accepted"))
-	})
+	}))
 
 	mux.Handle("/metrics", promhttp.Handler())
 	return mux
@@ -168,9 +141,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	auth, err := httpauth.Open(cfg.Auth)
+	if err != nil {
+		log.Println("ERROR:", err)
+		os.Exit(1)
+	}
+
 	s := &http.Server{
 		Addr:         ":3040",
-		Handler:      newMux(cfg),
+		Handler:      newMux(cfg, huebridge.New(cfg.HueIPAddress, cfg.HueID), auth),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}